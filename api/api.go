@@ -0,0 +1,129 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/guillembonet/backup/daemon"
+	"github.com/rs/zerolog/log"
+)
+
+// API is a local HTTP API to inspect and trigger the jobs of a daemon.
+type API struct {
+	daemon *daemon.Daemon
+	token  string
+}
+
+// New creates the API of a daemon. If token is not empty every request must
+// carry it as a bearer token.
+func New(d *daemon.Daemon, token string) *API {
+	return &API{
+		daemon: d,
+		token:  token,
+	}
+}
+
+// Serve serves the API on the given address until ctx is cancelled. Runs
+// triggered through the API are cancelled with ctx too.
+func (a *API) Serve(ctx context.Context, listen string) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", a.handleStatus)
+	mux.HandleFunc("/run", func(w http.ResponseWriter, r *http.Request) {
+		a.handleRun(ctx, w, r)
+	})
+	mux.HandleFunc("/backups", a.handleBackups)
+	server := &http.Server{
+		Addr:    listen,
+		Handler: a.authenticate(mux),
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		err := server.Shutdown(shutdownCtx)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to shut down api server")
+		}
+	}()
+
+	log.Info().Str("listen", listen).Msg("serving api")
+	err := server.ListenAndServe()
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("failed to serve api: %w", err)
+	}
+	return nil
+}
+
+func (a *API) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if a.token != "" {
+			token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(a.token)) != 1 {
+				writeError(w, http.StatusUnauthorized, "invalid token")
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handleStatus returns the current and last run state of every job.
+func (a *API) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	writeJSON(w, http.StatusOK, a.daemon.Status())
+}
+
+// handleRun triggers a run of the job given in the job query parameter, or of
+// every job if it is missing.
+func (a *API) handleRun(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	job := r.URL.Query().Get("job")
+	err := a.daemon.Trigger(ctx, job)
+	switch {
+	case errors.Is(err, daemon.ErrUnknownJob):
+		writeError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, daemon.ErrSkipped):
+		writeError(w, http.StatusConflict, err.Error())
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, err.Error())
+	default:
+		log.Info().Str("job", job).Msg("backup triggered through the api")
+		writeJSON(w, http.StatusAccepted, map[string]string{"status": "triggered"})
+	}
+}
+
+// handleBackups lists the backups stored in the targets of every job.
+func (a *API) handleBackups(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	writeJSON(w, http.StatusOK, a.daemon.Backups(r.Context()))
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(body)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to write response")
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
@@ -0,0 +1,203 @@
+package archive
+
+import (
+	"archive/zip"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+	"time"
+)
+
+// Writer adds files to a backup archive. Writers returned by Sub share the
+// underlying archive and write inside a directory of it.
+type Writer struct {
+	zip    *zip.Writer
+	prefix string
+	stats  *stats
+}
+
+type stats struct {
+	files    int
+	bytes    int64
+	metadata map[string]map[string]string
+	skipped  []FileIssue
+	flagged  []FileIssue
+}
+
+// FileIssue is a problem with a file of a source which didn't fail the backup.
+type FileIssue struct {
+	Path   string `json:"path"`
+	Reason string `json:"reason"`
+}
+
+// NewWriter creates an archive which is written to w.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{
+		zip: zip.NewWriter(w),
+		stats: &stats{
+			metadata: map[string]map[string]string{},
+		},
+	}
+}
+
+// Sub returns a writer which adds files inside dir.
+func (w *Writer) Sub(dir string) *Writer {
+	return &Writer{
+		zip:    w.zip,
+		prefix: w.name(dir),
+		stats:  w.stats,
+	}
+}
+
+// AddDir adds a directory entry. An empty name adds the directory of the
+// writer itself.
+func (w *Writer) AddDir(name string, info fs.FileInfo) error {
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	header.Name = w.name(name) + "/"
+	_, err = w.zip.CreateHeader(header)
+	return err
+}
+
+// AddFile adds a regular file with the content read from r and returns the
+// number of bytes read.
+func (w *Writer) AddFile(name string, info fs.FileInfo, r io.Reader) (int64, error) {
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return 0, err
+	}
+	header.Name = w.name(name)
+	header.Method = zip.Deflate
+
+	writer, err := w.zip.CreateHeader(header)
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(writer, r)
+	w.stats.files++
+	w.stats.bytes += n
+	return n, err
+}
+
+// AddSymlink adds a symbolic link pointing to target, which is stored as its
+// content.
+func (w *Writer) AddSymlink(name string, info fs.FileInfo, target string) error {
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	header.Name = w.name(name)
+
+	writer, err := w.zip.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(writer, target)
+	return err
+}
+
+// AddSpecial adds a file which is neither regular, a directory nor a symbolic
+// link, e.g. a named pipe. Only its type and metadata are stored.
+func (w *Writer) AddSpecial(name string, info fs.FileInfo) error {
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	header.Name = w.name(name)
+	_, err = w.zip.CreateHeader(header)
+	return err
+}
+
+// CreateFile adds a regular file whose content is written to the returned
+// writer, for content which is streamed rather than read from a file. The file
+// must be fully written before adding anything else to the archive.
+func (w *Writer) CreateFile(name string, mode fs.FileMode, modTime time.Time) (io.Writer, error) {
+	header := &zip.FileHeader{
+		Name:     w.name(name),
+		Method:   zip.Deflate,
+		Modified: modTime,
+	}
+	header.SetMode(mode)
+
+	writer, err := w.zip.CreateHeader(header)
+	if err != nil {
+		return nil, err
+	}
+	w.stats.files++
+	return &countingWriter{writer: writer, stats: w.stats}, nil
+}
+
+// SetMetadata records metadata of a file, e.g. of the object it was read from,
+// which cannot be stored in the archive itself.
+func (w *Writer) SetMetadata(name string, metadata map[string]string) {
+	w.stats.metadata[w.name(name)] = metadata
+}
+
+// Metadata returns the metadata recorded for the files of the archive by their
+// name in it.
+func (w *Writer) Metadata() map[string]map[string]string {
+	return w.stats.metadata
+}
+
+// Skip records a file of a source which was left out of the archive, e.g.
+// because it could not be read.
+func (w *Writer) Skip(path string, reason string) {
+	w.stats.skipped = append(w.stats.skipped, FileIssue{Path: path, Reason: reason})
+}
+
+// Skipped returns the files left out of the archive.
+func (w *Writer) Skipped() []FileIssue {
+	return w.stats.skipped
+}
+
+// Flag records a file of a source which was added to the archive but may be
+// inconsistent, e.g. because it changed while being read.
+func (w *Writer) Flag(path string, reason string) {
+	w.stats.flagged = append(w.stats.flagged, FileIssue{Path: path, Reason: reason})
+}
+
+// Flagged returns the files which may be inconsistent in the archive.
+func (w *Writer) Flagged() []FileIssue {
+	return w.stats.flagged
+}
+
+// Files returns the number of files added to the archive.
+func (w *Writer) Files() int {
+	return w.stats.files
+}
+
+// Bytes returns the number of bytes of the files added to the archive, before
+// compression.
+func (w *Writer) Bytes() int64 {
+	return w.stats.bytes
+}
+
+// Close finishes the archive. It doesn't close the underlying writer.
+func (w *Writer) Close() error {
+	return w.zip.Close()
+}
+
+func (w *Writer) name(name string) string {
+	name = strings.Trim(name, "/")
+	if w.prefix == "" {
+		return name
+	}
+	if name == "" {
+		return w.prefix
+	}
+	return path.Join(w.prefix, name)
+}
+
+type countingWriter struct {
+	writer io.Writer
+	stats  *stats
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.writer.Write(p)
+	w.stats.bytes += int64(n)
+	return n, err
+}
@@ -0,0 +1,164 @@
+package archive
+
+import (
+	"archive/zip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/fs"
+)
+
+// sparseExtraID identifies the extra field of sparse files. Their content only
+// holds their data segments, one after the other, and the extra field their
+// size and where each segment goes.
+const sparseExtraID = 0x5053
+
+// MaxSegments is the most data segments which fit in an extra field. The
+// extra fields of a file are limited to 0xffff bytes, which also hold the
+// extended timestamp added by zip.Writer and, in the central directory, the
+// zip64 sizes of files of 4 GiB or more.
+const MaxSegments = (0xffff - extendedTimestampExtraLen - zip64ExtraLen - 4 - 8) / 16
+
+// Lengths of the extra fields zip.Writer adds, including their headers.
+const (
+	extendedTimestampExtraLen = 9
+	zip64ExtraLen             = 28
+)
+
+// Segment is a range of a sparse file holding data, the rest of it are holes
+// which read as zeros.
+type Segment struct {
+	Offset int64
+	Length int64
+}
+
+// AddSparseFile adds a regular file with holes, reading the data of each
+// segment from r. It returns the number of bytes read, which is the total
+// length of the segments unless the file shrank, and fails if there are more
+// than MaxSegments segments.
+func (w *Writer) AddSparseFile(name string, info fs.FileInfo, segments []Segment, r io.ReaderAt) (int64, error) {
+	if len(segments) > MaxSegments {
+		return 0, fmt.Errorf("too many data segments: %d", len(segments))
+	}
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return 0, err
+	}
+	header.Name = w.name(name)
+	header.Method = zip.Deflate
+	header.Extra = append(header.Extra, sparseExtra(info.Size(), segments)...)
+
+	writer, err := w.zip.CreateHeader(header)
+	if err != nil {
+		return 0, err
+	}
+	var n int64
+	for _, segment := range segments {
+		copied, err := io.Copy(writer, io.NewSectionReader(r, segment.Offset, segment.Length))
+		n += copied
+		if err == nil && copied < segment.Length {
+			// the file shrank while being read, pad the segment so that the
+			// content still matches the extra field
+			_, err = io.CopyN(writer, zeros{}, segment.Length-copied)
+		}
+		if err != nil {
+			w.stats.files++
+			w.stats.bytes += n
+			return n, err
+		}
+	}
+	w.stats.files++
+	w.stats.bytes += n
+	return n, nil
+}
+
+func sparseExtra(size int64, segments []Segment) []byte {
+	data := make([]byte, 4+8+16*len(segments))
+	binary.LittleEndian.PutUint16(data[0:], sparseExtraID)
+	binary.LittleEndian.PutUint16(data[2:], uint16(len(data)-4))
+	binary.LittleEndian.PutUint64(data[4:], uint64(size))
+	for i, segment := range segments {
+		binary.LittleEndian.PutUint64(data[12+16*i:], uint64(segment.Offset))
+		binary.LittleEndian.PutUint64(data[20+16*i:], uint64(segment.Length))
+	}
+	return data
+}
+
+// SparseMap returns the size and the data segments of a sparse file of an
+// archive. ok is false if the file is not sparse.
+func SparseMap(file *zip.File) (size int64, segments []Segment, ok bool) {
+	extra := file.Extra
+	for len(extra) >= 4 {
+		id := binary.LittleEndian.Uint16(extra[0:])
+		length := int(binary.LittleEndian.Uint16(extra[2:]))
+		if len(extra) < 4+length {
+			break
+		}
+		data := extra[4 : 4+length]
+		extra = extra[4+length:]
+		if id != sparseExtraID || length < 8 || (length-8)%16 != 0 {
+			continue
+		}
+
+		size = int64(binary.LittleEndian.Uint64(data[0:]))
+		segments = make([]Segment, (length-8)/16)
+		for i := range segments {
+			segments[i] = Segment{
+				Offset: int64(binary.LittleEndian.Uint64(data[8+16*i:])),
+				Length: int64(binary.LittleEndian.Uint64(data[16+16*i:])),
+			}
+		}
+		return size, segments, true
+	}
+	return 0, nil, false
+}
+
+// Size returns the size of a file of an archive once extracted, which for
+// sparse files is larger than their stored content.
+func Size(file *zip.File) int64 {
+	if size, _, ok := SparseMap(file); ok {
+		return size
+	}
+	return int64(file.UncompressedSize64)
+}
+
+// Open opens a file of an archive, reading the holes of sparse files as zeros.
+func Open(file *zip.File) (io.ReadCloser, error) {
+	content, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	size, segments, ok := SparseMap(file)
+	if !ok {
+		return content, nil
+	}
+
+	readers := []io.Reader{}
+	position := int64(0)
+	for _, segment := range segments {
+		readers = append(readers,
+			io.LimitReader(zeros{}, segment.Offset-position),
+			io.LimitReader(content, segment.Length),
+		)
+		position = segment.Offset + segment.Length
+	}
+	readers = append(readers, io.LimitReader(zeros{}, size-position))
+	return readCloser{
+		Reader: io.MultiReader(readers...),
+		Closer: content,
+	}, nil
+}
+
+type zeros struct{}
+
+func (zeros) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,122 @@
+package archive
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"io/fs"
+	"testing"
+	"time"
+)
+
+type fileInfo struct {
+	size int64
+}
+
+func (i fileInfo) Name() string       { return "disk.img" }
+func (i fileInfo) Size() int64        { return i.size }
+func (i fileInfo) Mode() fs.FileMode  { return 0644 }
+func (i fileInfo) ModTime() time.Time { return time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC) }
+func (i fileInfo) IsDir() bool        { return false }
+func (i fileInfo) Sys() any           { return nil }
+
+// sparseFile returns the content of a file with a one byte data segment every
+// other byte, and the segments.
+func sparseFile(count int) ([]byte, []Segment) {
+	content := make([]byte, 2*count+1)
+	segments := make([]Segment, count)
+	for i := range segments {
+		offset := int64(2*i + 1)
+		content[offset] = byte(i%255 + 1)
+		segments[i] = Segment{Offset: offset, Length: 1}
+	}
+	return content, segments
+}
+
+func TestSparseFileRoundTrip(t *testing.T) {
+	content, segments := sparseFile(MaxSegments)
+	buf := &bytes.Buffer{}
+	writer := NewWriter(buf)
+	n, err := writer.AddSparseFile("disk.img", fileInfo{size: int64(len(content))}, segments, bytes.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(len(segments)) {
+		t.Errorf("got %d bytes read, want %d", n, len(segments))
+	}
+	err = writer.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reader, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := reader.File[0]
+	size, readSegments, ok := SparseMap(file)
+	if !ok || size != int64(len(content)) || len(readSegments) != len(segments) {
+		t.Fatalf("got sparse map of size %d with %d segments, want %d with %d", size, len(readSegments), len(content), len(segments))
+	}
+	if Size(file) != int64(len(content)) {
+		t.Errorf("got size %d, want %d", Size(file), len(content))
+	}
+
+	r, err := Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Error("content read back differs")
+	}
+}
+
+func TestSparseFileTooManySegments(t *testing.T) {
+	content, segments := sparseFile(MaxSegments + 1)
+	writer := NewWriter(io.Discard)
+	_, err := writer.AddSparseFile("disk.img", fileInfo{size: int64(len(content))}, segments, bytes.NewReader(content))
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestSparseFileShrunk(t *testing.T) {
+	content, segments := sparseFile(3)
+	buf := &bytes.Buffer{}
+	writer := NewWriter(buf)
+	// the last segment can't be read anymore
+	n, err := writer.AddSparseFile("disk.img", fileInfo{size: int64(len(content))}, segments, bytes.NewReader(content[:5]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("got %d bytes read, want 2", n)
+	}
+	err = writer.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reader, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := Open(reader.File[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := append(content[:5:5], 0, 0)
+	if !bytes.Equal(data, want) {
+		t.Errorf("got %v, want %v", data, want)
+	}
+}
@@ -1,21 +1,31 @@
 package backup
 
 import (
-	"archive/zip"
 	"bytes"
+	"context"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/sha256"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
-	"strings"
 	"time"
 
+	"github.com/guillembonet/backup/archive"
 	"github.com/guillembonet/backup/config"
+	"github.com/guillembonet/backup/healthcheck"
+	"github.com/guillembonet/backup/hooks"
 	"github.com/guillembonet/backup/sources"
+	"github.com/guillembonet/backup/sources/command"
+	"github.com/guillembonet/backup/sources/filter"
 	"github.com/guillembonet/backup/sources/folder"
+	"github.com/guillembonet/backup/sources/git"
+	"github.com/guillembonet/backup/sources/postgres"
+	"github.com/guillembonet/backup/sources/s3"
+	"github.com/guillembonet/backup/sources/sftp"
+	"github.com/guillembonet/backup/sources/sqlite"
 	"github.com/guillembonet/backup/targets"
 	"github.com/guillembonet/backup/targets/mega"
 	"github.com/rs/zerolog/log"
@@ -23,21 +33,97 @@ import (
 )
 
 type Backup struct {
-	cfg     config.Backup
-	sources []sources.Source
-	targets []targets.Target
+	name            string
+	cfg             config.Backup
+	sources         []sources.Source
+	manifestSources []ManifestSource
+	targets         []targets.Target
+	healthcheck     *healthcheck.Pinger
+	workDir         string
 }
 
-func New(cfg config.Backup) (*Backup, error) {
+// New creates the backup service of a job. Working files are written to
+// workDir, or to the system temp dir if it is empty.
+func New(job config.Job, workDir string) (*Backup, error) {
+	cfg := job.Backup
 	sources := make([]sources.Source, len(cfg.Sources))
+	manifestSources := make([]ManifestSource, len(cfg.Sources))
+	ids := map[string]bool{}
 	for i, source := range cfg.Sources {
+		manifestSource, err := newManifestSource(source)
+		if err != nil {
+			return nil, err
+		}
+		if ids[manifestSource.ID] {
+			return nil, fmt.Errorf("duplicated source id: %s", manifestSource.ID)
+		}
+		ids[manifestSource.ID] = true
+		manifestSources[i] = manifestSource
+
 		switch source.Type {
 		case "folder":
-			s, err := folder.NewSource(source.Path)
+			f, err := filter.New(source.Include, source.Exclude)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create folder source: %w", err)
+			}
+			s, err := folder.NewSource(source.Path, folder.Options{
+				Filter:           f,
+				OnError:          source.OnError,
+				FollowSymlinks:   source.FollowSymlinks,
+				OneFileSystem:    source.OneFileSystem,
+				SkipSpecialFiles: source.SkipSpecialFiles,
+				IncludeCaches:    source.IncludeCaches,
+			})
 			if err != nil {
 				return nil, fmt.Errorf("failed to create folder source: %w", err)
 			}
 			sources[i] = s
+		case "command":
+			s, err := command.NewSource(source.Command, source.FileName)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create command source: %w", err)
+			}
+			sources[i] = s
+		case "sqlite":
+			s, err := sqlite.NewSource(source.GetPaths(), func() (string, func() error, error) {
+				dir, err := NewWorkDir(workDir)
+				if err != nil {
+					return "", nil, err
+				}
+				return dir.Path, dir.Remove, nil
+			})
+			if err != nil {
+				return nil, fmt.Errorf("failed to create sqlite source: %w", err)
+			}
+			sources[i] = s
+		case "postgres":
+			s, err := postgres.NewSource(source.ConnectionString, source.Databases)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create postgres source: %w", err)
+			}
+			sources[i] = s
+		case "git":
+			s, err := git.NewSource(source.GetPaths())
+			if err != nil {
+				return nil, fmt.Errorf("failed to create git source: %w", err)
+			}
+			sources[i] = s
+		case "sftp":
+			f, err := filter.New(source.Include, source.Exclude)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create sftp source: %w", err)
+			}
+			s, err := sftp.NewSource(source.Path, source.Config, f)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create sftp source: %w", err)
+			}
+			sources[i] = s
+		case "s3":
+			s, err := s3.NewSource(source.Config)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create s3 source: %w", err)
+			}
+			sources[i] = s
 		default:
 			return nil, fmt.Errorf("unknown source type: %s", source.Type)
 		}
@@ -57,284 +143,278 @@ func New(cfg config.Backup) (*Backup, error) {
 		}
 	}
 	return &Backup{
-		cfg:     cfg,
-		sources: sources,
-		targets: targets,
+		name:            job.Name,
+		cfg:             cfg,
+		sources:         sources,
+		manifestSources: manifestSources,
+		targets:         targets,
+		healthcheck:     healthcheck.New(cfg.Healthcheck),
+		workDir:         workDir,
 	}, nil
 }
 
-func (b *Backup) Run() error {
-	backupDest, err := os.MkdirTemp("", "backup")
+// Run creates an encrypted backup and uploads it to every target, cleaning the
+// expired backups afterwards. A failing target doesn't prevent uploading to
+// the other ones. The returned report is never nil.
+func (b *Backup) Run(ctx context.Context) (*Report, error) {
+	b.healthcheck.Start()
+	report, err := b.run(ctx)
+	b.finishHooks(b.cfg.Hooks, b.hookEnv(nil, report), err)
+	if err != nil {
+		b.healthcheck.Fail(err)
+	} else {
+		b.healthcheck.Success()
+	}
+	return report, err
+}
+
+func (b *Backup) run(ctx context.Context) (*Report, error) {
+	report := &Report{
+		StartedAt: time.Now(),
+		Skipped:   []archive.FileIssue{},
+		Flagged:   []archive.FileIssue{},
+		Targets:   []TargetReport{},
+	}
+	defer func() {
+		report.FinishedAt = time.Now()
+	}()
+
+	err := hooks.Run(ctx, b.cfg.Hooks.Pre, b.cfg.Hooks.Timeout, b.hookEnv(nil, nil))
+	if err != nil {
+		return report, fmt.Errorf("failed to run pre hooks: %w", err)
+	}
+
+	backupDest, err := NewWorkDir(b.workDir)
 	if err != nil {
-		return fmt.Errorf("failed to create backup destination: %w", err)
+		return report, fmt.Errorf("failed to create backup destination: %w", err)
 	}
+	defer backupDest.Remove()
 
 	encryptedFileName := fmt.Sprintf("backup_%s.bin", time.Now().Format("2006-01-02_15-04-05"))
-	encryptedFileDest := filepath.Join(backupDest, encryptedFileName)
-	err = b.Encrypt(encryptedFileDest)
+	encryptedFileDest := filepath.Join(backupDest.Path, encryptedFileName)
+	err = b.encrypt(ctx, encryptedFileDest, report)
 	if err != nil {
-		return fmt.Errorf("failed to encrypt backup: %w", err)
+		return report, fmt.Errorf("failed to encrypt backup: %w", err)
 	}
 
+	var errs []error
 	for i, target := range b.targets {
-		err := target.Upload(encryptedFileDest)
-		if err != nil {
-			return fmt.Errorf("failed to upload backup: %w", err)
+		targetReport := TargetReport{
+			Name: targetName(b.cfg.Targets[i]),
 		}
-		err = target.Clean(b.cfg.Targets[i].BackupExpirationDays)
+		err := b.upload(ctx, target, b.cfg.Targets[i], encryptedFileDest, report.ArchiveSize, &targetReport)
 		if err != nil {
-			return fmt.Errorf("failed to clean old backups in target: %w", err)
+			targetReport.Error = err.Error()
+			errs = append(errs, fmt.Errorf("target %s: %w", targetReport.Name, err))
 		}
+		report.Targets = append(report.Targets, targetReport)
 	}
 
-	return nil
+	return report, errors.Join(errs...)
 }
 
-func (b *Backup) Encrypt(encryptedFilePath string) error {
-	backupDest, err := os.MkdirTemp("", "backup")
-	if err != nil {
-		return fmt.Errorf("failed to create backup destination: %w", err)
-	}
-	for _, source := range b.sources {
-		err := source.Backup(backupDest)
-		if err != nil {
-			return fmt.Errorf("failed to backup source: %w", err)
-		}
-	}
-	log.Debug().Str("destination", backupDest).Msg("backuped up data")
-
-	compressedBackupFileName := fmt.Sprintf("backup_%s.zip", time.Now().Format("2006-01-02_15-04-05"))
-	compressedBackupDest := filepath.Join(backupDest, compressedBackupFileName)
-	err = compress(backupDest, compressedBackupDest)
+func (b *Backup) upload(ctx context.Context, target targets.Target, cfg config.Target, filePath string, size int64, report *TargetReport) error {
+	err := target.Upload(ctx, filePath)
 	if err != nil {
-		return fmt.Errorf("failed to compress backup: %w", err)
+		return fmt.Errorf("failed to upload backup: %w", err)
 	}
-	log.Debug().Str("destination", compressedBackupDest).Msg("compressed backup")
+	report.UploadedBytes = size
 
-	err = delete(backupDest, compressedBackupFileName)
+	report.Deleted, err = target.Clean(ctx, cfg.BackupExpirationDays)
 	if err != nil {
-		return fmt.Errorf("failed to delete uncompressed backup: %w", err)
+		return fmt.Errorf("failed to clean old backups in target: %w", err)
 	}
-	log.Debug().Msg("deleted uncompressed backup")
+	return nil
+}
 
-	err = encrypt(compressedBackupDest, encryptedFilePath, b.cfg.EncryptionPassword)
-	if err != nil {
-		return fmt.Errorf("failed to encrypt backup: %w", err)
+// Encrypt backs up every source into a single encrypted file.
+func (b *Backup) Encrypt(ctx context.Context, encryptedFilePath string) (*Report, error) {
+	report := &Report{
+		StartedAt: time.Now(),
+		Skipped:   []archive.FileIssue{},
+		Flagged:   []archive.FileIssue{},
+		Targets:   []TargetReport{},
 	}
-	log.Debug().Str("destination", encryptedFilePath).Msg("encrypted backup")
-	return nil
+	err := b.encrypt(ctx, encryptedFilePath, report)
+	report.FinishedAt = time.Now()
+	return report, err
 }
 
-func compress(folder, destination string) error {
-	// create a new zip archive
-	zipFile, err := os.Create(destination)
+func (b *Backup) encrypt(ctx context.Context, encryptedFilePath string, report *Report) (err error) {
+	file, err := os.Create(encryptedFilePath)
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to create encrypted backup: %w", err)
 	}
-	defer zipFile.Close()
-
-	archive := zip.NewWriter(zipFile)
-	defer archive.Close()
-
-	// walk the folder and add files to the zip archive
-	err = filepath.Walk(folder, func(path string, info os.FileInfo, err error) error {
+	defer func() {
+		file.Close()
 		if err != nil {
-			return err
+			os.Remove(encryptedFilePath)
 		}
+	}()
 
-		if path == folder || path == destination {
-			return nil
-		}
-
-		// create a new file header for the file
-		header, err := zip.FileInfoHeader(info)
+	// the archive is encrypted while it is written so that no plaintext data
+	// touches the disk
+	encrypted, err := newEncryptWriter(file, b.cfg.EncryptionPassword)
+	if err != nil {
+		return fmt.Errorf("failed to create encrypter: %w", err)
+	}
+	writer := archive.NewWriter(encrypted)
+	for i := range b.sources {
+		// every source gets its own folder so that sources with the same name don't collide
+		err := b.backupSource(ctx, i, writer.Sub(b.manifestSources[i].ID))
 		if err != nil {
-			return err
-		}
-
-		// set the file name to be relative to the source directory
-		header.Name = strings.TrimPrefix(path, folder+string(os.PathSeparator))
-
-		if info.IsDir() {
-			// if the file is a directory, create it in the archive with an empty header
-			header.Name += "/"
-			_, err = archive.CreateHeader(header)
-			if err != nil {
-				return err
-			}
-		} else {
-			// if the file is not a directory, create it in the archive with its contents
-			file, err := os.Open(path)
-			if err != nil {
-				return err
-			}
-			defer file.Close()
-
-			writer, err := archive.CreateHeader(header)
-			if err != nil {
-				return err
-			}
-
-			_, err = io.Copy(writer, file)
-			if err != nil {
-				return err
-			}
+			return fmt.Errorf("failed to backup source: %w", err)
 		}
-
-		return nil
+	}
+	report.FilesProcessed, report.BytesRead = writer.Files(), writer.Bytes()
+	report.Skipped = append(report.Skipped, writer.Skipped()...)
+	report.Flagged = append(report.Flagged, writer.Flagged()...)
+	logIssues(report.Skipped, "skipped files")
+	logIssues(report.Flagged, "files which may be inconsistent in the backup")
+	log.Debug().Int("files", report.FilesProcessed).Int64("bytes", report.BytesRead).Msg("backed up data")
+
+	err = writeManifest(writer, Manifest{
+		CreatedAt: time.Now(),
+		Sources:   b.manifestSources,
+		Metadata:  writer.Metadata(),
 	})
-
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to write manifest: %w", err)
 	}
 
-	return nil
-}
-
-func encrypt(file string, encryptedFile string, password string) error {
-	// read the plaintext file
-	fileData, err := os.ReadFile(file)
+	err = writer.Close()
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to compress backup: %w", err)
 	}
-
-	// generate a 32-byte key and a 16-byte initialization vector from the password
-	key, iv := generateKeyAndIV(password)
-
-	// create a new AES cipher block
-	block, err := aes.NewCipher(key)
+	err = encrypted.Close()
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to encrypt backup: %w", err)
 	}
-
-	// create a new CBC mode block cipher with the AES cipher and the initialization vector
-	mode := cipher.NewCBCEncrypter(block, iv)
-
-	// pad the plaintext to a multiple of the block size
-	fileData = pad(fileData, block.BlockSize())
-
-	// create a new buffer to hold the encrypted data
-	ciphertext := make([]byte, len(fileData))
-
-	// encrypt the plaintext using the CBC mode block cipher
-	mode.CryptBlocks(ciphertext, fileData)
-
-	// write the encrypted data to the encrypted file
-	err = os.WriteFile(encryptedFile, ciphertext, 0644)
+	err = file.Close()
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to write encrypted backup: %w", err)
 	}
-
+	report.ArchiveSize = encrypted.written
+	log.Debug().Str("destination", encryptedFilePath).Int64("size", report.ArchiveSize).Msg("encrypted backup")
 	return nil
 }
 
-func Decrypt(encryptedFile string, decryptedFile string, password string) error {
-	// read the encrypted file
-	ciphertext, err := os.ReadFile(encryptedFile)
+// logIssues logs a summary of the files with issues, if any.
+func logIssues(issues []archive.FileIssue, msg string) {
+	if len(issues) == 0 {
+		return
+	}
+	paths := make([]string, len(issues))
+	for i, issue := range issues {
+		paths[i] = issue.Path
+	}
+	log.Warn().Int("count", len(issues)).Strs("paths", paths).Msg(msg)
+}
+
+// backupSource backs up the i-th source into the archive, running its hooks
+// around it.
+func (b *Backup) backupSource(ctx context.Context, i int, writer *archive.Writer) error {
+	cfg := b.cfg.Sources[i]
+	env := b.hookEnv(&b.manifestSources[i], nil)
+
+	err := hooks.Run(ctx, cfg.Hooks.Pre, cfg.Hooks.Timeout, env)
 	if err != nil {
-		return err
+		err = fmt.Errorf("failed to run pre hooks: %w", err)
+	} else {
+		err = b.sources[i].Backup(ctx, writer)
 	}
+	b.finishHooks(cfg.Hooks, env, err)
+	return err
+}
 
+// encryptWriter encrypts everything written to it with AES-256-CBC, padding
+// the last block on Close. The output is the same as encrypting the whole
+// content at once.
+type encryptWriter struct {
+	writer  io.Writer
+	mode    cipher.BlockMode
+	pending []byte
+	written int64
+}
+
+func newEncryptWriter(writer io.Writer, password string) (*encryptWriter, error) {
 	// generate a 32-byte key and a 16-byte initialization vector from the password
 	key, iv := generateKeyAndIV(password)
 
 	// create a new AES cipher block
 	block, err := aes.NewCipher(key)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	// create a new CBC mode block cipher with the AES cipher and the initialization vector
-	mode := cipher.NewCBCDecrypter(block, iv)
-
-	// create a new buffer to hold the decrypted data
-	fileData := make([]byte, len(ciphertext))
-
-	// decrypt the ciphertext using the CBC mode block cipher
-	mode.CryptBlocks(fileData, ciphertext)
-
-	// unpad the decrypted data
-	fileData = unpad(fileData)
+	return &encryptWriter{
+		writer: writer,
+		mode:   cipher.NewCBCEncrypter(block, iv),
+	}, nil
+}
 
-	// write the decrypted data to the decrypted file
-	err = os.WriteFile(decryptedFile, fileData, 0644)
+func (w *encryptWriter) Write(p []byte) (int, error) {
+	w.pending = append(w.pending, p...)
+	// only whole blocks can be encrypted, the rest waits for more data
+	n := len(w.pending) - len(w.pending)%w.mode.BlockSize()
+	if n == 0 {
+		return len(p), nil
+	}
+	err := w.flush(w.pending[:n])
 	if err != nil {
-		return err
+		return 0, err
 	}
+	w.pending = w.pending[:copy(w.pending, w.pending[n:])]
+	return len(p), nil
+}
 
-	return nil
+// Close pads and encrypts the remaining data. It doesn't close the underlying
+// writer.
+func (w *encryptWriter) Close() error {
+	err := w.flush(pad(w.pending, w.mode.BlockSize()))
+	w.pending = nil
+	return err
 }
 
-func Restore(backupFile string, restoreDest string, password string) error {
-	// decrypt the backup file
-	decryptedFilePath := filepath.Dir(backupFile)
-	decryptedFilePath = filepath.Join(decryptedFilePath, strings.TrimSuffix(filepath.Base(backupFile), ".bin")+".zip")
-	err := Decrypt(backupFile, decryptedFilePath, password)
-	if err != nil {
-		return fmt.Errorf("failed to decrypt backup: %w", err)
-	}
+func (w *encryptWriter) flush(blocks []byte) error {
+	w.mode.CryptBlocks(blocks, blocks)
+	n, err := w.writer.Write(blocks)
+	w.written += int64(n)
+	return err
+}
 
-	// decompress the backup file
-	err = Decompress(decryptedFilePath, restoreDest)
+func Decrypt(encryptedFile string, decryptedFile string, password string) error {
+	file, err := os.Open(encryptedFile)
 	if err != nil {
-		return fmt.Errorf("failed to decompress backup: %w", err)
+		return err
 	}
+	defer file.Close()
 
-	// delete the decrypted backup file
-	err = os.Remove(decryptedFilePath)
+	plaintext, err := newDecryptReader(file, password)
 	if err != nil {
-		return fmt.Errorf("failed to delete decrypted backup: %w", err)
+		return err
 	}
 
-	return nil
-}
-
-func Decompress(backupFile string, destination string) error {
-	// open the zip archive
-	zipReader, err := zip.OpenReader(backupFile)
+	// write the decrypted data to the decrypted file
+	output, err := os.OpenFile(decryptedFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
 	if err != nil {
 		return err
 	}
-	defer zipReader.Close()
-
-	// walk the files in the archive
-	for _, file := range zipReader.File {
-		// create a new file in the destination
-		filePath := filepath.Join(destination, file.Name)
-		if file.FileInfo().IsDir() {
-			// if the file is a directory, create it
-			err = os.MkdirAll(filePath, os.ModePerm)
-			if err != nil {
-				return err
-			}
-		} else {
-			// if the file is not a directory, create it and copy the contents
-			fileReader, err := file.Open()
-			if err != nil {
-				return err
-			}
-			defer fileReader.Close()
-
-			fileWriter, err := os.Create(filePath)
-			if err != nil {
-				return err
-			}
-			defer fileWriter.Close()
-
-			_, err = io.Copy(fileWriter, fileReader)
-			if err != nil {
-				return err
-			}
-		}
+	defer output.Close()
+	_, err = io.Copy(output, io.NewSectionReader(plaintext, 0, plaintext.Size()))
+	if err != nil {
+		return err
 	}
-
-	return nil
+	return output.Close()
 }
 
 // unpad removes the PKCS7 padding from the input
-func unpad(input []byte) []byte {
+func unpad(input []byte, blockSize int) ([]byte, error) {
 	paddingSize := int(input[len(input)-1])
-	return input[:len(input)-paddingSize]
+	if paddingSize == 0 || paddingSize > blockSize || paddingSize > len(input) {
+		return nil, fmt.Errorf("invalid padding, wrong password?")
+	}
+	return input[:len(input)-paddingSize], nil
 }
 
 // generateKeyAndIV generates a 32-byte key and a 16-byte initialization vector from a password
@@ -359,40 +439,3 @@ func pad(input []byte, blockSize int) []byte {
 	padding := bytes.Repeat([]byte{byte(paddingSize)}, paddingSize)
 	return append(input, padding...)
 }
-
-func delete(folderPath, whitelistedFileName string) error {
-	// get a list of all files and folders inside the specified folder
-	files, err := os.ReadDir(folderPath)
-	if err != nil {
-		return fmt.Errorf("failed to read directory: %w", err)
-	}
-
-	// loop through each file and folder
-	for _, file := range files {
-		// check if the current file is the one to keep
-		if file.Name() == whitelistedFileName {
-			continue
-		}
-
-		// create the full path to the current file
-		filePath := filepath.Join(folderPath, file.Name())
-
-		// check if the current file is a directory
-		if file.IsDir() {
-			// if it is, delete the directory and its contents
-			err := os.RemoveAll(filePath)
-			if err != nil {
-				return fmt.Errorf("failed to remove directory: %w", err)
-			}
-		} else {
-			// if it's a file, delete the file
-			err := os.Remove(filePath)
-			if err != nil {
-				fmt.Println(err)
-				return fmt.Errorf("failed to remove file: %w", err)
-			}
-		}
-	}
-
-	return nil
-}
@@ -0,0 +1,152 @@
+package backup
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/guillembonet/backup/archive"
+	"github.com/guillembonet/backup/targets"
+)
+
+// Entry describes a single file or directory stored in a backup archive.
+type Entry struct {
+	Name    string      `json:"name"`
+	Size    int64       `json:"size"`
+	Mode    os.FileMode `json:"mode"`
+	ModTime time.Time   `json:"mod_time"`
+	CRC32   uint32      `json:"crc32"`
+}
+
+// List returns the entries of an encrypted backup file whose name starts with
+// prefix. An empty prefix lists every entry.
+func List(backupFile string, password string, prefix string) ([]Entry, error) {
+	zipReader, closeArchive, err := openArchive(backupFile, password)
+	if err != nil {
+		return nil, err
+	}
+	defer closeArchive()
+
+	prefix = strings.TrimPrefix(prefix, "/")
+	entries := []Entry{}
+	for _, file := range zipReader.File {
+		if !strings.HasPrefix(file.Name, prefix) {
+			continue
+		}
+		entries = append(entries, Entry{
+			Name:    file.Name,
+			Size:    archive.Size(file),
+			Mode:    file.Mode(),
+			ModTime: file.Modified,
+			CRC32:   file.CRC32,
+		})
+	}
+	return entries, nil
+}
+
+// Cat writes the contents of a single file stored in an encrypted backup file
+// to w.
+func Cat(backupFile string, password string, path string, w io.Writer) error {
+	zipReader, closeArchive, err := openArchive(backupFile, password)
+	if err != nil {
+		return err
+	}
+	defer closeArchive()
+
+	path = strings.TrimPrefix(path, "/")
+	for _, file := range zipReader.File {
+		if file.Name != path {
+			continue
+		}
+		if file.FileInfo().IsDir() {
+			return fmt.Errorf("%s is a directory", path)
+		}
+		// holes of sparse files are written as zeros
+		fileReader, err := archive.Open(file)
+		if err != nil {
+			return fmt.Errorf("failed to open file: %w", err)
+		}
+		defer fileReader.Close()
+
+		_, err = io.Copy(w, fileReader)
+		if err != nil {
+			return fmt.Errorf("failed to read file: %w", err)
+		}
+		return nil
+	}
+	return fmt.Errorf("%s not found in backup", path)
+}
+
+// Download fetches a backup file from the target with the given name into
+// destination. Targets are matched by their configured name, or by their type
+// if they have none.
+func (b *Backup) Download(ctx context.Context, name string, fileName string, destination string) error {
+	for i, target := range b.targets {
+		if targetName(b.cfg.Targets[i]) != name {
+			continue
+		}
+		downloader, ok := target.(targets.Downloader)
+		if !ok {
+			return fmt.Errorf("target %s does not support downloads", name)
+		}
+		return downloader.Download(ctx, fileName, destination)
+	}
+	return fmt.Errorf("unknown target: %s", name)
+}
+
+// RemoteBackups lists the backup files stored in a target.
+type RemoteBackups struct {
+	Target string         `json:"target"`
+	Files  []targets.File `json:"files"`
+	Error  string         `json:"error,omitempty"`
+}
+
+// ListRemote lists the backup files stored in every target which supports
+// listing. A target failing to list is reported in its entry.
+func (b *Backup) ListRemote(ctx context.Context) []RemoteBackups {
+	remotes := []RemoteBackups{}
+	for i, target := range b.targets {
+		lister, ok := target.(targets.Lister)
+		if !ok {
+			continue
+		}
+		remote := RemoteBackups{
+			Target: targetName(b.cfg.Targets[i]),
+			Files:  []targets.File{},
+		}
+		files, err := lister.List(ctx)
+		if err != nil {
+			remote.Error = err.Error()
+		} else {
+			remote.Files = files
+		}
+		remotes = append(remotes, remote)
+	}
+	return remotes
+}
+
+// openArchive opens a backup file as a zip archive, decrypting only the parts
+// which are read. The returned function closes the file.
+func openArchive(backupFile string, password string) (*zip.Reader, func() error, error) {
+	file, err := os.Open(backupFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read backup: %w", err)
+	}
+
+	plaintext, err := newDecryptReader(file, password)
+	if err != nil {
+		file.Close()
+		return nil, nil, fmt.Errorf("failed to decrypt backup: %w", err)
+	}
+
+	zipReader, err := zip.NewReader(plaintext, plaintext.Size())
+	if err != nil {
+		file.Close()
+		return nil, nil, fmt.Errorf("failed to open archive: %w", err)
+	}
+	return zipReader, file.Close, nil
+}
@@ -0,0 +1,108 @@
+package backup
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"fmt"
+	"io"
+	"os"
+)
+
+// decryptReader decrypts a backup file on demand. CBC blocks only depend on
+// the previous ciphertext block, so any range of the plaintext can be read
+// without decrypting what comes before it.
+type decryptReader struct {
+	file  *os.File
+	block cipher.Block
+	iv    []byte
+	// size is the length of the plaintext, without padding
+	size int64
+}
+
+func newDecryptReader(file *os.File, password string) (*decryptReader, error) {
+	key, iv := generateKeyAndIV(password)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() == 0 || info.Size()%aes.BlockSize != 0 {
+		return nil, fmt.Errorf("encrypted data is not a multiple of the block size")
+	}
+
+	r := &decryptReader{
+		file:  file,
+		block: block,
+		iv:    iv,
+		size:  info.Size(),
+	}
+	// the padding is in the last block
+	last := make([]byte, aes.BlockSize)
+	err = r.decryptBlocks(last, info.Size()/aes.BlockSize-1)
+	if err != nil {
+		return nil, err
+	}
+	unpadded, err := unpad(last, aes.BlockSize)
+	if err != nil {
+		return nil, err
+	}
+	r.size -= int64(aes.BlockSize - len(unpadded))
+	return r, nil
+}
+
+// Size returns the length of the plaintext.
+func (r *decryptReader) Size() int64 {
+	return r.size
+}
+
+func (r *decryptReader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset")
+	}
+	if off >= r.size {
+		return 0, io.EOF
+	}
+	end := off + int64(len(p))
+	if end > r.size {
+		end = r.size
+	}
+
+	first := off / aes.BlockSize
+	last := (end + aes.BlockSize - 1) / aes.BlockSize
+	plaintext := make([]byte, (last-first)*aes.BlockSize)
+	err := r.decryptBlocks(plaintext, first)
+	if err != nil {
+		return 0, err
+	}
+
+	n := copy(p, plaintext[off-first*aes.BlockSize:end-first*aes.BlockSize])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// decryptBlocks decrypts len(dst) bytes of blocks starting with the block at
+// the given index.
+func (r *decryptReader) decryptBlocks(dst []byte, index int64) error {
+	// each block is chained to the previous one, the first one to the IV
+	iv := r.iv
+	start := index * aes.BlockSize
+	if index > 0 {
+		start -= aes.BlockSize
+	}
+	ciphertext := make([]byte, int64(len(dst))+index*aes.BlockSize-start)
+	_, err := r.file.ReadAt(ciphertext, start)
+	if err != nil {
+		return fmt.Errorf("failed to read backup: %w", err)
+	}
+	if index > 0 {
+		iv, ciphertext = ciphertext[:aes.BlockSize], ciphertext[aes.BlockSize:]
+	}
+	cipher.NewCBCDecrypter(r.block, iv).CryptBlocks(dst, ciphertext)
+	return nil
+}
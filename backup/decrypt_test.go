@@ -0,0 +1,87 @@
+package backup
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func encryptToFile(t *testing.T, plaintext []byte, password string) *os.File {
+	t.Helper()
+	file, err := os.Create(filepath.Join(t.TempDir(), "backup.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { file.Close() })
+
+	encrypter, err := newEncryptWriter(file, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = encrypter.Write(plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = encrypter.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestDecryptReader(t *testing.T) {
+	for _, size := range []int{0, 1, 15, 16, 17, 1000, 4096} {
+		plaintext := make([]byte, size)
+		_, err := rand.Read(plaintext)
+		if err != nil {
+			t.Fatal(err)
+		}
+		file := encryptToFile(t, plaintext, "password")
+
+		r, err := newDecryptReader(file, "password")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.Size() != int64(size) {
+			t.Fatalf("got size %d, want %d", r.Size(), size)
+		}
+
+		data, err := io.ReadAll(io.NewSectionReader(r, 0, r.Size()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, plaintext) {
+			t.Fatalf("size %d: content differs", size)
+		}
+
+		// ranges starting and ending in the middle of blocks
+		for off := 0; off < size; off += 7 {
+			for _, length := range []int{1, 16, 33} {
+				p := make([]byte, length)
+				n, err := r.ReadAt(p, int64(off))
+				want := plaintext[off:]
+				if len(want) > length {
+					want = want[:length]
+				}
+				if n != len(want) || !bytes.Equal(p[:n], want) {
+					t.Fatalf("size %d: ReadAt(%d, %d) read %d bytes, want %d", size, length, off, n, len(want))
+				}
+				if n < length && err != io.EOF {
+					t.Fatalf("size %d: short ReadAt(%d, %d) returned %v instead of EOF", size, length, off, err)
+				}
+			}
+		}
+	}
+}
+
+func TestDecryptReaderWrongPassword(t *testing.T) {
+	file := encryptToFile(t, []byte("some content"), "password")
+
+	_, err := newDecryptReader(file, "wrong")
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+}
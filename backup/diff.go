@@ -0,0 +1,89 @@
+package backup
+
+import "sort"
+
+// DiffEntry describes a file which differs between two backups.
+type DiffEntry struct {
+	Name      string `json:"name"`
+	OldSize   int64  `json:"old_size"`
+	NewSize   int64  `json:"new_size"`
+	SizeDelta int64  `json:"size_delta"`
+}
+
+// DiffReport lists the files added, removed and modified between two backups.
+type DiffReport struct {
+	Added     []DiffEntry `json:"added"`
+	Removed   []DiffEntry `json:"removed"`
+	Modified  []DiffEntry `json:"modified"`
+	OldSize   int64       `json:"old_size"`
+	NewSize   int64       `json:"new_size"`
+	SizeDelta int64       `json:"size_delta"`
+}
+
+// Diff compares the entries of two backups. Directories and the manifest are
+// ignored and files are considered modified when their size or checksum
+// changed.
+func Diff(oldEntries []Entry, newEntries []Entry) *DiffReport {
+	report := &DiffReport{
+		Added:    []DiffEntry{},
+		Removed:  []DiffEntry{},
+		Modified: []DiffEntry{},
+	}
+
+	oldFiles := map[string]Entry{}
+	for _, entry := range oldEntries {
+		if entry.Mode.IsDir() || entry.Name == manifestName {
+			continue
+		}
+		oldFiles[entry.Name] = entry
+		report.OldSize += entry.Size
+	}
+
+	seen := map[string]bool{}
+	for _, entry := range newEntries {
+		if entry.Mode.IsDir() || entry.Name == manifestName {
+			continue
+		}
+		report.NewSize += entry.Size
+
+		old, ok := oldFiles[entry.Name]
+		if !ok {
+			report.Added = append(report.Added, DiffEntry{
+				Name:      entry.Name,
+				NewSize:   entry.Size,
+				SizeDelta: entry.Size,
+			})
+			continue
+		}
+		seen[entry.Name] = true
+
+		if old.Size != entry.Size || old.CRC32 != entry.CRC32 {
+			report.Modified = append(report.Modified, DiffEntry{
+				Name:      entry.Name,
+				OldSize:   old.Size,
+				NewSize:   entry.Size,
+				SizeDelta: entry.Size - old.Size,
+			})
+		}
+	}
+
+	for _, old := range oldFiles {
+		if seen[old.Name] {
+			continue
+		}
+		report.Removed = append(report.Removed, DiffEntry{
+			Name:      old.Name,
+			OldSize:   old.Size,
+			SizeDelta: -old.Size,
+		})
+	}
+	// archives aren't necessarily sorted and the removed files come from a map
+	for _, entries := range [][]DiffEntry{report.Added, report.Removed, report.Modified} {
+		sort.Slice(entries, func(i, j int) bool {
+			return entries[i].Name < entries[j].Name
+		})
+	}
+
+	report.SizeDelta = report.NewSize - report.OldSize
+	return report
+}
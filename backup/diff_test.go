@@ -0,0 +1,51 @@
+package backup
+
+import (
+	"io/fs"
+	"reflect"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	oldEntries := []Entry{
+		{Name: manifestName, Size: 100},
+		{Name: "src/", Mode: fs.ModeDir},
+		{Name: "src/same", Size: 10, CRC32: 1},
+		{Name: "src/resized", Size: 10, CRC32: 2},
+		{Name: "src/rewritten", Size: 10, CRC32: 3},
+		{Name: "src/z-removed", Size: 5},
+		{Name: "src/a-removed", Size: 7},
+	}
+	newEntries := []Entry{
+		{Name: manifestName, Size: 200},
+		{Name: "src/", Mode: fs.ModeDir},
+		{Name: "src/z-added", Size: 3},
+		{Name: "src/rewritten", Size: 10, CRC32: 4},
+		{Name: "src/same", Size: 10, CRC32: 1},
+		{Name: "src/resized", Size: 25, CRC32: 5},
+		{Name: "src/a-added", Size: 1},
+	}
+
+	report := Diff(oldEntries, newEntries)
+
+	want := &DiffReport{
+		Added: []DiffEntry{
+			{Name: "src/a-added", NewSize: 1, SizeDelta: 1},
+			{Name: "src/z-added", NewSize: 3, SizeDelta: 3},
+		},
+		Removed: []DiffEntry{
+			{Name: "src/a-removed", OldSize: 7, SizeDelta: -7},
+			{Name: "src/z-removed", OldSize: 5, SizeDelta: -5},
+		},
+		Modified: []DiffEntry{
+			{Name: "src/resized", OldSize: 10, NewSize: 25, SizeDelta: 15},
+			{Name: "src/rewritten", OldSize: 10, NewSize: 10},
+		},
+		OldSize:   42,
+		NewSize:   49,
+		SizeDelta: 7,
+	}
+	if !reflect.DeepEqual(report, want) {
+		t.Errorf("got %+v, want %+v", report, want)
+	}
+}
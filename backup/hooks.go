@@ -0,0 +1,51 @@
+package backup
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/guillembonet/backup/config"
+	"github.com/guillembonet/backup/hooks"
+	"github.com/rs/zerolog/log"
+)
+
+// hookEnv returns the environment variables describing the run to hooks. The
+// source and the report are only set for the hooks which have them.
+func (b *Backup) hookEnv(source *ManifestSource, report *Report) map[string]string {
+	env := map[string]string{
+		"BACKUP_JOB":    b.name,
+		"BACKUP_STATUS": "running",
+	}
+	if source != nil {
+		env["BACKUP_SOURCE_ID"] = source.ID
+		env["BACKUP_SOURCE_TYPE"] = source.Type
+		env["BACKUP_SOURCE_PATH"] = source.Path
+	}
+	if report != nil {
+		env["BACKUP_FILES"] = strconv.Itoa(report.FilesProcessed)
+		env["BACKUP_ARCHIVE_SIZE"] = strconv.FormatInt(report.ArchiveSize, 10)
+	}
+	return env
+}
+
+// finishHooks runs the on_error hooks if the run failed and then the post
+// hooks. They are not bound to the context of the run so that they can undo
+// what the pre hooks did even if it was cancelled. Their failures are logged
+// without affecting the outcome of the run.
+func (b *Backup) finishHooks(cfg config.Hooks, env map[string]string, runErr error) {
+	env["BACKUP_STATUS"] = "success"
+	if runErr != nil {
+		env["BACKUP_STATUS"] = "failure"
+		env["BACKUP_ERROR"] = runErr.Error()
+
+		err := hooks.Run(context.Background(), cfg.OnError, cfg.Timeout, env)
+		if err != nil {
+			log.Error().Err(err).Str("job", b.name).Msg("failed to run on error hooks")
+		}
+	}
+
+	err := hooks.Run(context.Background(), cfg.Post, cfg.Timeout, env)
+	if err != nil {
+		log.Error().Err(err).Str("job", b.name).Msg("failed to run post hooks")
+	}
+}
@@ -0,0 +1,143 @@
+package backup
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/guillembonet/backup/archive"
+	"github.com/guillembonet/backup/config"
+)
+
+// manifestName is the name of the file at the root of every archive which
+// describes its content.
+const manifestName = "manifest.json"
+
+// Manifest describes where the content of an archive comes from. Every source
+// is stored in a top-level directory named after its ID.
+type Manifest struct {
+	CreatedAt time.Time        `json:"created_at"`
+	Sources   []ManifestSource `json:"sources"`
+	// Metadata holds what sources record about some files, e.g. the metadata
+	// of the objects of s3 sources, by the name of the file in the archive.
+	Metadata map[string]map[string]string `json:"metadata,omitempty"`
+}
+
+// ManifestSource records the origin of a source stored in an archive.
+type ManifestSource struct {
+	ID   string `json:"id"`
+	Type string `json:"type"`
+	Path string `json:"path"`
+}
+
+// newManifestSource resolves the local path a source is restored to and its
+// ID. If no ID is configured one is derived from where the source reads from
+// so that sources with the same base name do not collide.
+func newManifestSource(source config.Source) (ManifestSource, error) {
+	path, origin := "", ""
+	switch source.Type {
+	case "command":
+		origin = source.Command
+	case "postgres":
+		origin = source.ConnectionString
+	case "sftp":
+		// remote files have no local path to be restored to
+		origin = source.Config["address"] + ":" + source.Path
+	case "s3":
+		origin = source.Config["endpoint"] + "/" + source.Config["bucket"] + "/" + source.Config["prefix"]
+	default:
+		paths := source.GetPaths()
+		for i := range paths {
+			var err error
+			paths[i], err = filepath.Abs(paths[i])
+			if err != nil {
+				return ManifestSource{}, fmt.Errorf("failed to resolve source path: %w", err)
+			}
+		}
+		origin = strings.Join(paths, "\n")
+
+		switch {
+		case source.Type == "sqlite":
+			// databases are stored by file name, so they can only be restored
+			// to their original location if they share a directory
+			path = commonDir(paths)
+		case source.Type == "git":
+			// bundles are not the content of the repositories, they are
+			// restored by cloning them
+		case len(paths) > 0:
+			path = paths[0]
+		}
+	}
+
+	id := source.ID
+	if id == "" {
+		name := filepath.Base(path)
+		if path == "" {
+			name = source.Type
+		}
+		hash := sha256.Sum256([]byte(origin))
+		id = fmt.Sprintf("%s-%s", name, hex.EncodeToString(hash[:4]))
+	}
+	if id == "." || id == ".." || id == manifestName || strings.ContainsAny(id, `/\`) {
+		return ManifestSource{}, fmt.Errorf("invalid source id: %s", id)
+	}
+
+	return ManifestSource{
+		ID:   id,
+		Type: source.Type,
+		Path: path,
+	}, nil
+}
+
+// commonDir returns the directory containing every file, or an empty string
+// if they are in different directories.
+func commonDir(files []string) string {
+	dir := ""
+	for i, file := range files {
+		if i == 0 {
+			dir = filepath.Dir(file)
+		} else if filepath.Dir(file) != dir {
+			return ""
+		}
+	}
+	return dir
+}
+
+func writeManifest(writer *archive.Writer, manifest Manifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	file, err := writer.CreateFile(manifestName, 0644, manifest.CreatedAt)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	return err
+}
+
+// readManifest returns the manifest of an archive, or nil if the archive was
+// created before manifests existed.
+func readManifest(archive *zip.Reader) (*Manifest, error) {
+	file, err := archive.Open(manifestName)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var manifest Manifest
+	err = json.NewDecoder(file).Decode(&manifest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode manifest: %w", err)
+	}
+	return &manifest, nil
+}
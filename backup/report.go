@@ -0,0 +1,46 @@
+package backup
+
+import (
+	"time"
+
+	"github.com/guillembonet/backup/archive"
+	"github.com/guillembonet/backup/config"
+)
+
+// Report summarizes a backup run.
+type Report struct {
+	StartedAt      time.Time `json:"started_at"`
+	FinishedAt     time.Time `json:"finished_at"`
+	FilesProcessed int       `json:"files_processed"`
+	BytesRead      int64     `json:"bytes_read"`
+	ArchiveSize    int64     `json:"archive_size"`
+	// Skipped are the files left out of the archive because of errors which
+	// the sources were configured to tolerate.
+	Skipped []archive.FileIssue `json:"skipped"`
+	// Flagged are the files which may be inconsistent in the archive, e.g.
+	// because they changed while being read.
+	Flagged []archive.FileIssue `json:"flagged"`
+	Targets []TargetReport      `json:"targets"`
+}
+
+// TargetReport summarizes what happened in a single target during a run.
+type TargetReport struct {
+	Name          string `json:"name"`
+	UploadedBytes int64  `json:"uploaded_bytes"`
+	Deleted       int    `json:"deleted"`
+	Error         string `json:"error,omitempty"`
+}
+
+// Duration returns how long the run took.
+func (r *Report) Duration() time.Duration {
+	return r.FinishedAt.Sub(r.StartedAt)
+}
+
+// targetName returns the name a target is referred by, which is its type if no
+// name is configured.
+func targetName(target config.Target) string {
+	if target.Name != "" {
+		return target.Name
+	}
+	return target.Type
+}
@@ -0,0 +1,435 @@
+package backup
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/guillembonet/backup/archive"
+)
+
+// ConflictPolicy decides what happens when a restored file already exists.
+type ConflictPolicy string
+
+const (
+	ConflictSkip      ConflictPolicy = "skip"
+	ConflictOverwrite ConflictPolicy = "overwrite"
+	ConflictRename    ConflictPolicy = "rename"
+	ConflictIfNewer   ConflictPolicy = "if-newer"
+)
+
+// RestoreOptions controls how a backup is extracted.
+type RestoreOptions struct {
+	// Strict aborts the restore on the first unsafe entry instead of skipping it.
+	Strict bool
+	// OnConflict is the policy for files which already exist, defaults to
+	// ConflictOverwrite.
+	OnConflict ConflictPolicy
+	// DryRun only reports what would be written without touching the disk.
+	DryRun bool
+	// ToOriginal restores every source to the path it was backed up from
+	// instead of the destination.
+	ToOriginal bool
+	// Mappings relocates sources, keys are source IDs or original paths (or a
+	// parent of them) and values the path to restore to.
+	Mappings map[string]string
+}
+
+// Restore actions reported for every file in the archive.
+const (
+	ActionCreate    = "create"
+	ActionOverwrite = "overwrite"
+	ActionRename    = "rename"
+	ActionSkip      = "skip"
+)
+
+// RestoreReport summarizes the outcome of a restore.
+type RestoreReport struct {
+	Actions  []RestoreAction `json:"actions"`
+	Rejected []RejectedEntry `json:"rejected"`
+}
+
+// RestoreAction is what was done, or would be done in a dry run, with a file
+// from the archive.
+type RestoreAction struct {
+	Name   string `json:"name"`
+	Path   string `json:"path"`
+	Action string `json:"action"`
+}
+
+// Count returns the number of files restored with the given action.
+func (r *RestoreReport) Count(action string) int {
+	count := 0
+	for _, a := range r.Actions {
+		if a.Action == action {
+			count++
+		}
+	}
+	return count
+}
+
+// RejectedEntry is an archive entry which was not restored because it was
+// considered unsafe.
+type RejectedEntry struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+func Restore(ctx context.Context, backupFile string, restoreDest string, password string, opts RestoreOptions) (*RestoreReport, error) {
+	// decrypt the backup file as it is read, so that no plaintext is written
+	zipReader, closeArchive, err := openArchive(backupFile, password)
+	if err != nil {
+		return nil, err
+	}
+	defer closeArchive()
+
+	report, err := decompress(ctx, zipReader, restoreDest, opts)
+	if err != nil {
+		return report, fmt.Errorf("failed to decompress backup: %w", err)
+	}
+	return report, nil
+}
+
+// Decompress extracts a zip archive which is not encrypted into destination.
+func Decompress(ctx context.Context, zipFile string, destination string, opts RestoreOptions) (*RestoreReport, error) {
+	zipReader, err := zip.OpenReader(zipFile)
+	if err != nil {
+		return nil, err
+	}
+	defer zipReader.Close()
+	return decompress(ctx, &zipReader.Reader, destination, opts)
+}
+
+func decompress(ctx context.Context, zipReader *zip.Reader, destination string, opts RestoreOptions) (*RestoreReport, error) {
+	report := &RestoreReport{
+		Actions:  []RestoreAction{},
+		Rejected: []RejectedEntry{},
+	}
+
+	switch opts.OnConflict {
+	case "":
+		opts.OnConflict = ConflictOverwrite
+	case ConflictSkip, ConflictOverwrite, ConflictRename, ConflictIfNewer:
+	default:
+		return report, fmt.Errorf("unknown conflict policy: %s", opts.OnConflict)
+	}
+
+	destination, err := filepath.Abs(destination)
+	if err != nil {
+		return report, err
+	}
+
+	// find where each source has to be restored if it is not the destination
+	roots := map[string]string{}
+	if opts.ToOriginal || len(opts.Mappings) > 0 {
+		manifest, err := readManifest(zipReader)
+		if err != nil {
+			return report, err
+		}
+		if manifest == nil {
+			return report, fmt.Errorf("backup has no manifest, cannot restore to original paths")
+		}
+		used := map[string]bool{}
+		for _, source := range manifest.Sources {
+			root, mapping := relocate(source, opts.Mappings)
+			used[mapping] = true
+			// sources without a path, like commands, have no original location
+			if mapping != "" || (opts.ToOriginal && source.Path != "") {
+				roots[source.ID] = root
+			}
+		}
+		// a mistyped mapping would silently restore to the destination
+		for mapping := range opts.Mappings {
+			if !used[mapping] {
+				return report, fmt.Errorf("mapping %s matches no source of the backup", mapping)
+			}
+		}
+	}
+
+	// walk the files in the archive
+	for _, file := range zipReader.File {
+		if ctx.Err() != nil {
+			return report, ctx.Err()
+		}
+		if file.Name == manifestName {
+			continue
+		}
+
+		base, name := destination, file.Name
+		id, rest, _ := strings.Cut(file.Name, "/")
+		if root, ok := roots[id]; ok {
+			base, name = root, rest
+			if name == "" {
+				name = "."
+			}
+		}
+
+		filePath, err := safePath(base, name)
+		if err != nil {
+			if opts.Strict {
+				return report, fmt.Errorf("unsafe entry %q: %w", file.Name, err)
+			}
+			report.Rejected = append(report.Rejected, RejectedEntry{
+				Name:   file.Name,
+				Reason: err.Error(),
+			})
+			continue
+		}
+
+		if file.FileInfo().IsDir() {
+			if opts.DryRun {
+				continue
+			}
+			// if the file is a directory, create it
+			err = os.MkdirAll(filePath, os.ModePerm)
+			if err != nil {
+				return report, err
+			}
+			continue
+		}
+
+		if !restorable(file.Mode()) {
+			report.Rejected = append(report.Rejected, RejectedEntry{
+				Name:   file.Name,
+				Reason: fmt.Sprintf("cannot restore special file with mode %s", file.Mode()),
+			})
+			continue
+		}
+
+		// if the file is not a directory, decide what to do if it already exists
+		action, err := resolveConflict(file, filePath, opts.OnConflict)
+		if err != nil {
+			return report, err
+		}
+		report.Actions = append(report.Actions, action)
+		if opts.DryRun || action.Action == ActionSkip {
+			continue
+		}
+
+		// create it and copy the contents
+		err = extractEntry(file, action.Path)
+		if err != nil {
+			return report, err
+		}
+	}
+
+	return report, nil
+}
+
+// relocate returns the path a source has to be restored to, which is its
+// original path unless a mapping matches its ID or path. The second return
+// value is the matching mapping, empty if none matched.
+func relocate(source ManifestSource, mappings map[string]string) (string, string) {
+	if newPath, ok := mappings[source.ID]; ok {
+		return newPath, source.ID
+	}
+
+	// use the longest matching path so that the most specific mapping wins
+	root, matched, mapping := source.Path, "", ""
+	for oldPath, newPath := range mappings {
+		cleaned := filepath.Clean(oldPath)
+		if len(cleaned) <= len(matched) {
+			continue
+		}
+		if source.Path == cleaned {
+			root, matched, mapping = newPath, cleaned, oldPath
+		} else if strings.HasPrefix(source.Path, strings.TrimSuffix(cleaned, string(os.PathSeparator))+string(os.PathSeparator)) {
+			root, matched, mapping = filepath.Join(newPath, strings.TrimPrefix(source.Path, cleaned)), cleaned, oldPath
+		}
+	}
+	return root, mapping
+}
+
+// resolveConflict decides how a file from the archive is written to filePath
+// according to the conflict policy.
+func resolveConflict(file *zip.File, filePath string, policy ConflictPolicy) (RestoreAction, error) {
+	action := RestoreAction{
+		Name:   file.Name,
+		Path:   filePath,
+		Action: ActionCreate,
+	}
+
+	info, err := os.Lstat(filePath)
+	if os.IsNotExist(err) {
+		return action, nil
+	}
+	if err != nil {
+		return action, err
+	}
+
+	switch policy {
+	case ConflictSkip:
+		action.Action = ActionSkip
+	case ConflictOverwrite:
+		action.Action = ActionOverwrite
+	case ConflictIfNewer:
+		action.Action = ActionSkip
+		if file.Modified.After(info.ModTime()) {
+			action.Action = ActionOverwrite
+		}
+	case ConflictRename:
+		action.Action = ActionRename
+		for i := 1; ; i++ {
+			action.Path = fmt.Sprintf("%s.restored.%d", filePath, i)
+			_, err = os.Stat(action.Path)
+			if os.IsNotExist(err) {
+				break
+			}
+			if err != nil {
+				return action, err
+			}
+		}
+	}
+	return action, nil
+}
+
+// restorable reports whether files with the given mode can be restored, which
+// are regular files, symlinks and named pipes besides directories.
+func restorable(mode fs.FileMode) bool {
+	return mode&(fs.ModeSocket|fs.ModeDevice|fs.ModeCharDevice|fs.ModeIrregular) == 0
+}
+
+// extractEntry writes a regular file, symlink or named pipe of the archive to
+// filePath, replacing what is there unless it is a directory.
+func extractEntry(file *zip.File, filePath string) error {
+	info, err := os.Lstat(filePath)
+	if err == nil {
+		if info.IsDir() {
+			return fmt.Errorf("%s is a directory", filePath)
+		}
+		// remove it instead of writing to it, which would follow a symlink
+		err = os.Remove(filePath)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	// streamed files have no directory entries in the archive
+	err = os.MkdirAll(filepath.Dir(filePath), os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	mode := file.Mode()
+	switch {
+	case mode&fs.ModeSymlink != 0:
+		return extractSymlink(file, filePath)
+	case mode&fs.ModeNamedPipe != 0:
+		err = syscall.Mkfifo(filePath, uint32(mode.Perm()))
+		if err != nil {
+			return fmt.Errorf("failed to create named pipe: %w", err)
+		}
+	default:
+		err = extractFile(file, filePath)
+		if err != nil {
+			return err
+		}
+	}
+
+	// keep the original modification time so that later restores can compare it
+	return os.Chtimes(filePath, file.Modified, file.Modified)
+}
+
+func extractFile(file *zip.File, filePath string) error {
+	fileReader, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer fileReader.Close()
+
+	fileWriter, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		return err
+	}
+	defer fileWriter.Close()
+
+	if size, segments, ok := archive.SparseMap(file); ok {
+		err = extractSparse(fileWriter, fileReader, size, segments)
+	} else {
+		_, err = io.Copy(fileWriter, fileReader)
+	}
+	if err != nil {
+		return err
+	}
+	return fileWriter.Close()
+}
+
+// extractSparse writes the data segments of a sparse file at their offsets,
+// leaving holes between them which the file system doesn't allocate.
+func extractSparse(fileWriter *os.File, content io.Reader, size int64, segments []archive.Segment) error {
+	for _, segment := range segments {
+		_, err := io.CopyN(io.NewOffsetWriter(fileWriter, segment.Offset), content, segment.Length)
+		if err != nil {
+			return fmt.Errorf("failed to write data segment: %w", err)
+		}
+	}
+	// a trailing hole is only there once the file is extended to its size
+	return fileWriter.Truncate(size)
+}
+
+// maxSymlinkTarget is the longest symlink target which is restored.
+const maxSymlinkTarget = 4096
+
+func extractSymlink(file *zip.File, filePath string) error {
+	fileReader, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer fileReader.Close()
+
+	target, err := io.ReadAll(io.LimitReader(fileReader, maxSymlinkTarget+1))
+	if err != nil {
+		return err
+	}
+	if len(target) == 0 || len(target) > maxSymlinkTarget {
+		return fmt.Errorf("invalid symlink target")
+	}
+	// the link is created as is, it is never followed while restoring
+	return os.Symlink(string(target), filePath)
+}
+
+// safePath resolves an archive entry name inside destination. It fails if the
+// name is absolute, escapes destination or goes through a symlink which
+// already exists on disk, e.g. one planted by an earlier entry. The entry
+// itself may be a symlink, as it is replaced rather than written through.
+func safePath(destination string, name string) (string, error) {
+	if name == "" {
+		return "", fmt.Errorf("empty name")
+	}
+	if strings.HasPrefix(name, "/") || strings.HasPrefix(name, `\`) || filepath.IsAbs(name) || filepath.VolumeName(name) != "" {
+		return "", fmt.Errorf("absolute path")
+	}
+
+	cleanName := filepath.Clean(filepath.FromSlash(name))
+	if cleanName == ".." || strings.HasPrefix(cleanName, ".."+string(os.PathSeparator)) {
+		return "", fmt.Errorf("path escapes destination")
+	}
+	if cleanName == "." {
+		return destination, nil
+	}
+
+	// make sure none of the parent directories is a symlink
+	current := destination
+	parts := strings.Split(cleanName, string(os.PathSeparator))
+	for _, part := range parts[:len(parts)-1] {
+		current = filepath.Join(current, part)
+		info, err := os.Lstat(current)
+		if os.IsNotExist(err) {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return "", fmt.Errorf("path goes through symlink %s", current)
+		}
+	}
+
+	return filepath.Join(destination, cleanName), nil
+}
@@ -0,0 +1,154 @@
+package backup
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/guillembonet/backup/sources/git"
+	"github.com/guillembonet/backup/sources/postgres"
+	"github.com/rs/zerolog/log"
+)
+
+// SourceRestoreOptions controls how sources are restored with their own tools
+// instead of extracting their files.
+type SourceRestoreOptions struct {
+	// ConnectionString connects to the server postgres databases are restored
+	// to, it must not be one of the restored databases.
+	ConnectionString string
+	// Clean replaces databases which already exist.
+	Clean bool
+	// Destination is the directory git repositories are cloned to.
+	Destination string
+	// DryRun only reports what would be restored.
+	DryRun bool
+}
+
+// restoreFunc restores a file of a source from its content, name is relative
+// to the directory of the source.
+type restoreFunc func(ctx context.Context, name string, content io.Reader) error
+
+// RestoreSources restores the sources of the given type in a backup with their
+// own tools, e.g. pg_restore for postgres sources or git clone for git ones,
+// and returns the names of the restored files.
+func RestoreSources(ctx context.Context, backupFile string, password string, sourceType string, opts SourceRestoreOptions) ([]string, error) {
+	restore, err := sourceRestorer(sourceType, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	zipReader, closeArchive, err := openArchive(backupFile, password)
+	if err != nil {
+		return nil, err
+	}
+	defer closeArchive()
+
+	manifest, err := readManifest(zipReader)
+	if err != nil {
+		return nil, err
+	}
+	if manifest == nil {
+		return nil, fmt.Errorf("backup has no manifest, cannot find its sources")
+	}
+	ids := map[string]bool{}
+	for _, source := range manifest.Sources {
+		if source.Type == sourceType {
+			ids[source.ID] = true
+		}
+	}
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("backup has no %s sources", sourceType)
+	}
+
+	restored := []string{}
+	for _, file := range zipReader.File {
+		if ctx.Err() != nil {
+			return restored, ctx.Err()
+		}
+		id, name, _ := strings.Cut(file.Name, "/")
+		if !ids[id] || name == "" || file.FileInfo().IsDir() {
+			continue
+		}
+		if !opts.DryRun {
+			err = restoreFile(ctx, file, name, restore)
+			if err != nil {
+				return restored, fmt.Errorf("failed to restore %s: %w", file.Name, err)
+			}
+			log.Info().Str("name", file.Name).Msg("restored source file")
+		}
+		restored = append(restored, file.Name)
+	}
+	return restored, nil
+}
+
+func sourceRestorer(sourceType string, opts SourceRestoreOptions) (restoreFunc, error) {
+	switch sourceType {
+	case "postgres":
+		if opts.ConnectionString == "" && !opts.DryRun {
+			return nil, fmt.Errorf("a connection string is required to restore postgres sources")
+		}
+		return func(ctx context.Context, name string, content io.Reader) error {
+			return postgres.Restore(ctx, opts.ConnectionString, content, opts.Clean)
+		}, nil
+	case "git":
+		destination, err := filepath.Abs(opts.Destination)
+		if err != nil {
+			return nil, err
+		}
+		return func(ctx context.Context, name string, content io.Reader) error {
+			return restoreGit(ctx, destination, name, content)
+		}, nil
+	default:
+		return nil, fmt.Errorf("restoring %s sources is not supported", sourceType)
+	}
+}
+
+func restoreFile(ctx context.Context, file *zip.File, name string, restore restoreFunc) error {
+	content, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+	return restore(ctx, name, content)
+}
+
+// restoreGit clones the repository of a bundle into destination, next to the
+// ones of the other bundles.
+func restoreGit(ctx context.Context, destination string, name string, content io.Reader) error {
+	if !strings.HasSuffix(name, git.BundleExtension) {
+		return nil
+	}
+	repoPath, err := safePath(destination, git.RepoName(name))
+	if err != nil {
+		return err
+	}
+	_, err = os.Stat(repoPath)
+	if err == nil {
+		return fmt.Errorf("%s already exists", repoPath)
+	}
+	err = os.MkdirAll(filepath.Dir(repoPath), os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	// git can only clone from a bundle file
+	bundle, err := os.CreateTemp(filepath.Dir(repoPath), ".restore-*"+git.BundleExtension)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(bundle.Name())
+	_, err = io.Copy(bundle, content)
+	if err != nil {
+		bundle.Close()
+		return err
+	}
+	err = bundle.Close()
+	if err != nil {
+		return err
+	}
+	return git.Restore(ctx, bundle.Name(), repoPath)
+}
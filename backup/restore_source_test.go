@@ -0,0 +1,106 @@
+package backup
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/guillembonet/backup/config"
+)
+
+// startPostgres starts a throwaway PostgreSQL cluster listening only on a unix
+// socket and returns a connection string to its postgres database.
+func startPostgres(t *testing.T) string {
+	t.Helper()
+	for _, tool := range []string{"initdb", "pg_ctl", "psql", "pg_dump", "pg_restore"} {
+		_, err := exec.LookPath(tool)
+		if err != nil {
+			t.Skipf("%s is not installed", tool)
+		}
+	}
+	if os.Geteuid() == 0 {
+		t.Skip("initdb cannot be run as root")
+	}
+
+	dataDir := filepath.Join(t.TempDir(), "data")
+	output, err := exec.Command("initdb", "--pgdata="+dataDir, "--username=postgres", "--auth=trust").CombinedOutput()
+	if err != nil {
+		t.Fatalf("initdb failed: %v\n%s", err, output)
+	}
+
+	// the path of unix sockets is limited to about a hundred bytes, which the
+	// test temp dirs may exceed
+	socketDir, err := os.MkdirTemp("", "pg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(socketDir) })
+
+	output, err = exec.Command("pg_ctl", "--pgdata="+dataDir, "--wait", "--log="+filepath.Join(dataDir, "log"),
+		"--options=-c listen_addresses='' -k "+socketDir, "start").CombinedOutput()
+	if err != nil {
+		t.Fatalf("pg_ctl start failed: %v\n%s", err, output)
+	}
+	t.Cleanup(func() {
+		exec.Command("pg_ctl", "--pgdata="+dataDir, "--wait", "--mode=immediate", "stop").Run()
+	})
+	return "host=" + socketDir + " user=postgres dbname=postgres"
+}
+
+func psql(t *testing.T, connectionString string, query string) string {
+	t.Helper()
+	output, err := exec.Command("psql", "--no-psqlrc", "--no-password", "--tuples-only", "--no-align",
+		"--set=ON_ERROR_STOP=1", "--dbname="+connectionString, "--command="+query).CombinedOutput()
+	if err != nil {
+		t.Fatalf("psql failed: %v\n%s", err, output)
+	}
+	return strings.TrimSpace(string(output))
+}
+
+func TestRestorePostgresSources(t *testing.T) {
+	connectionString := startPostgres(t)
+	appConnectionString := connectionString + " dbname=app"
+	psql(t, connectionString, "CREATE DATABASE app")
+	psql(t, appConnectionString, "CREATE TABLE items (id int PRIMARY KEY, name text)")
+	psql(t, appConnectionString, "INSERT INTO items SELECT i, 'item ' || i FROM generate_series(1, 100) i")
+	want := psql(t, appConnectionString, "SELECT id, name FROM items ORDER BY id")
+
+	b, err := New(config.Job{
+		Name: "pg",
+		Backup: config.Backup{
+			EncryptionPassword: "password",
+			Sources: []config.Source{{
+				Type:             "postgres",
+				ConnectionString: connectionString,
+				Databases:        []string{"app"},
+			}},
+		},
+	}, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	backupFile := filepath.Join(t.TempDir(), "backup.bin")
+	_, err = b.Encrypt(context.Background(), backupFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	psql(t, connectionString, "DROP DATABASE app")
+	restored, err := RestoreSources(context.Background(), backupFile, "password", "postgres", SourceRestoreOptions{
+		ConnectionString: connectionString,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(restored) != 1 || !strings.HasSuffix(restored[0], "/app.dump") {
+		t.Errorf("got restored files %v, want the dump of app", restored)
+	}
+
+	got := psql(t, appConnectionString, "SELECT id, name FROM items ORDER BY id")
+	if got != want {
+		t.Errorf("got rows %q, want %q", got, want)
+	}
+}
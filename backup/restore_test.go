@@ -0,0 +1,442 @@
+package backup
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type testEntry struct {
+	name     string
+	mode     fs.FileMode
+	content  string
+	modified time.Time
+}
+
+// writeZip creates an archive with the given entries, symlinks hold their
+// target as content.
+func writeZip(t *testing.T, entries []testEntry) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "backup.zip")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	writer := zip.NewWriter(file)
+	for _, entry := range entries {
+		header := &zip.FileHeader{Name: entry.name, Method: zip.Deflate, Modified: entry.modified}
+		header.SetMode(entry.mode)
+		w, err := writer.CreateHeader(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = w.Write([]byte(entry.content))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = writer.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// assertEmpty fails if anything was written in dir.
+func assertEmpty(t *testing.T, dir string) {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		t.Errorf("unexpected %s written to %s", entry.Name(), dir)
+	}
+}
+
+func TestDecompressRejectsUnsafeEntries(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		entry string
+	}{
+		{name: "parent", entry: "../evil"},
+		{name: "nested parent", entry: "src/../../evil"},
+		{name: "absolute", entry: "/evil"},
+		{name: "backslash absolute", entry: `\evil`},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			parent := t.TempDir()
+			destination := filepath.Join(parent, "restore")
+			backupFile := writeZip(t, []testEntry{
+				{name: test.entry, mode: 0644, content: "evil"},
+				{name: "src/ok.txt", mode: 0644, content: "ok"},
+			})
+
+			report, err := Decompress(context.Background(), backupFile, destination, RestoreOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(report.Rejected) != 1 || report.Rejected[0].Name != test.entry {
+				t.Errorf("got rejected %v, want only %s", report.Rejected, test.entry)
+			}
+			content, err := os.ReadFile(filepath.Join(destination, "src", "ok.txt"))
+			if err != nil || string(content) != "ok" {
+				t.Errorf("safe entry not restored: %v", err)
+			}
+			if _, err := os.Lstat(filepath.Join(parent, "evil")); !os.IsNotExist(err) {
+				t.Error("unsafe entry written outside of the destination")
+			}
+		})
+	}
+}
+
+func TestDecompressDoesNotWriteThroughSymlinks(t *testing.T) {
+	outside := t.TempDir()
+	destination := t.TempDir()
+	backupFile := writeZip(t, []testEntry{
+		// the link itself is fine, it is restored as is
+		{name: "src/link", mode: fs.ModeSymlink | 0777, content: outside},
+		{name: "src/link/evil", mode: 0644, content: "evil"},
+	})
+
+	report, err := Decompress(context.Background(), backupFile, destination, RestoreOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Rejected) != 1 || report.Rejected[0].Name != "src/link/evil" {
+		t.Errorf("got rejected %v, want only src/link/evil", report.Rejected)
+	}
+	target, err := os.Readlink(filepath.Join(destination, "src", "link"))
+	if err != nil || target != outside {
+		t.Errorf("symlink not restored: %q, %v", target, err)
+	}
+	assertEmpty(t, outside)
+}
+
+func TestDecompressReplacesSymlinksInsteadOfFollowingThem(t *testing.T) {
+	outside := t.TempDir()
+	destination := t.TempDir()
+	err := os.Symlink(filepath.Join(outside, "file"), filepath.Join(destination, "file"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	backupFile := writeZip(t, []testEntry{
+		{name: "file", mode: 0644, content: "content"},
+	})
+
+	_, err = Decompress(context.Background(), backupFile, destination, RestoreOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Lstat(filepath.Join(destination, "file"))
+	if err != nil || !info.Mode().IsRegular() {
+		t.Errorf("symlink not replaced by the file: %v", err)
+	}
+	assertEmpty(t, outside)
+}
+
+func TestDecompressStrict(t *testing.T) {
+	parent := t.TempDir()
+	destination := filepath.Join(parent, "restore")
+	backupFile := writeZip(t, []testEntry{
+		{name: "../evil", mode: 0644, content: "evil"},
+		{name: "src/ok.txt", mode: 0644, content: "ok"},
+	})
+
+	_, err := Decompress(context.Background(), backupFile, destination, RestoreOptions{Strict: true})
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if _, err := os.Lstat(filepath.Join(destination, "src", "ok.txt")); !os.IsNotExist(err) {
+		t.Error("entries after the unsafe one restored")
+	}
+	if _, err := os.Lstat(filepath.Join(parent, "evil")); !os.IsNotExist(err) {
+		t.Error("unsafe entry written outside of the destination")
+	}
+}
+
+// writeFile creates a file in dir with the given content and modification
+// time.
+func writeFile(t *testing.T, dir string, name string, content string, modified time.Time) {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	err := os.WriteFile(path, []byte(content), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chtimes(path, modified, modified)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// readFiles returns the content of every file in dir keyed by name.
+func readFiles(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{}
+	for _, entry := range entries {
+		content, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[entry.Name()] = string(content)
+	}
+	return files
+}
+
+func TestDecompressConflictPolicies(t *testing.T) {
+	existing := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	older, newer := existing.Add(-time.Hour), existing.Add(time.Hour)
+	for _, test := range []struct {
+		name     string
+		policy   ConflictPolicy
+		modified time.Time
+		// renamed is an earlier restore already renamed
+		renamed bool
+		action  string
+		want    map[string]string
+	}{
+		{name: "default", modified: newer, action: ActionOverwrite, want: map[string]string{"file": "new"}},
+		{name: "skip", policy: ConflictSkip, modified: newer, action: ActionSkip, want: map[string]string{"file": "old"}},
+		{name: "overwrite", policy: ConflictOverwrite, modified: older, action: ActionOverwrite, want: map[string]string{"file": "new"}},
+		{name: "rename", policy: ConflictRename, modified: newer, action: ActionRename, want: map[string]string{"file": "old", "file.restored.1": "new"}},
+		{name: "rename again", policy: ConflictRename, modified: newer, renamed: true, action: ActionRename, want: map[string]string{
+			"file": "old", "file.restored.1": "renamed", "file.restored.2": "new",
+		}},
+		{name: "if-newer with a newer file", policy: ConflictIfNewer, modified: newer, action: ActionOverwrite, want: map[string]string{"file": "new"}},
+		{name: "if-newer with an older file", policy: ConflictIfNewer, modified: older, action: ActionSkip, want: map[string]string{"file": "old"}},
+		{name: "if-newer with the same file", policy: ConflictIfNewer, modified: existing, action: ActionSkip, want: map[string]string{"file": "old"}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			destination := t.TempDir()
+			writeFile(t, destination, "file", "old", existing)
+			if test.renamed {
+				writeFile(t, destination, "file.restored.1", "renamed", existing)
+			}
+			backupFile := writeZip(t, []testEntry{
+				{name: "file", mode: 0644, content: "new", modified: test.modified},
+			})
+
+			report, err := Decompress(context.Background(), backupFile, destination, RestoreOptions{OnConflict: test.policy})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(report.Actions) != 1 || report.Actions[0].Action != test.action {
+				t.Errorf("got actions %v, want %s", report.Actions, test.action)
+			}
+			files := readFiles(t, destination)
+			if !reflect.DeepEqual(files, test.want) {
+				t.Errorf("got files %v, want %v", files, test.want)
+			}
+		})
+	}
+}
+
+func TestDecompressCreatesMissingFiles(t *testing.T) {
+	for _, policy := range []ConflictPolicy{ConflictSkip, ConflictOverwrite, ConflictRename, ConflictIfNewer} {
+		t.Run(string(policy), func(t *testing.T) {
+			destination := t.TempDir()
+			backupFile := writeZip(t, []testEntry{
+				{name: "file", mode: 0644, content: "new"},
+			})
+
+			report, err := Decompress(context.Background(), backupFile, destination, RestoreOptions{OnConflict: policy})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(report.Actions) != 1 || report.Actions[0].Action != ActionCreate {
+				t.Errorf("got actions %v, want %s", report.Actions, ActionCreate)
+			}
+			files := readFiles(t, destination)
+			if !reflect.DeepEqual(files, map[string]string{"file": "new"}) {
+				t.Errorf("got files %v", files)
+			}
+		})
+	}
+}
+
+func TestDecompressUnknownConflictPolicy(t *testing.T) {
+	destination := t.TempDir()
+	backupFile := writeZip(t, []testEntry{
+		{name: "file", mode: 0644, content: "new"},
+	})
+
+	_, err := Decompress(context.Background(), backupFile, destination, RestoreOptions{OnConflict: "fail"})
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	assertEmpty(t, destination)
+}
+
+func TestDecompressDryRun(t *testing.T) {
+	existing := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, policy := range []ConflictPolicy{ConflictSkip, ConflictOverwrite, ConflictRename, ConflictIfNewer} {
+		t.Run(string(policy), func(t *testing.T) {
+			destination := t.TempDir()
+			writeFile(t, destination, "file", "old", existing)
+			backupFile := writeZip(t, []testEntry{
+				{name: "dir/", mode: fs.ModeDir | 0755},
+				{name: "dir/new", mode: 0644, content: "new"},
+				{name: "file", mode: 0644, content: "new", modified: existing.Add(time.Hour)},
+				{name: "link", mode: fs.ModeSymlink | 0777, content: "file"},
+			})
+
+			report, err := Decompress(context.Background(), backupFile, destination, RestoreOptions{OnConflict: policy, DryRun: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(report.Actions) != 3 || report.Count(ActionCreate) != 2 {
+				t.Errorf("got actions %v, want 3 with 2 created", report.Actions)
+			}
+			files := readFiles(t, destination)
+			if !reflect.DeepEqual(files, map[string]string{"file": "old"}) {
+				t.Errorf("dry run wrote files: %v", files)
+			}
+			info, err := os.Stat(filepath.Join(destination, "file"))
+			if err != nil || !info.ModTime().Equal(existing) {
+				t.Errorf("dry run touched the existing file: %v", err)
+			}
+		})
+	}
+}
+
+// manifestEntry returns the manifest entry of an archive with the given
+// sources.
+func manifestEntry(t *testing.T, sources ...ManifestSource) testEntry {
+	t.Helper()
+	data, err := json.Marshal(Manifest{Sources: sources})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testEntry{name: manifestName, mode: 0644, content: string(data)}
+}
+
+func TestRelocate(t *testing.T) {
+	source := ManifestSource{ID: "docs", Type: "folder", Path: "/home/user/docs"}
+	for _, test := range []struct {
+		name     string
+		mappings map[string]string
+		root     string
+		mapping  string
+	}{
+		{name: "none", root: "/home/user/docs"},
+		{name: "id", mappings: map[string]string{"docs": "/restore"}, root: "/restore", mapping: "docs"},
+		{name: "path", mappings: map[string]string{"/home/user/docs/": "/restore"}, root: "/restore", mapping: "/home/user/docs/"},
+		{name: "parent", mappings: map[string]string{"/home": "/restore"}, root: "/restore/user/docs", mapping: "/home"},
+		{name: "longest parent", mappings: map[string]string{"/home": "/a", "/home/user": "/b"}, root: "/b/docs", mapping: "/home/user"},
+		{name: "id before path", mappings: map[string]string{"/home/user/docs": "/a", "docs": "/b"}, root: "/b", mapping: "docs"},
+		{name: "sibling", mappings: map[string]string{"/home/user/doc": "/restore"}, root: "/home/user/docs"},
+		{name: "other", mappings: map[string]string{"photos": "/restore"}, root: "/home/user/docs"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			root, mapping := relocate(source, test.mappings)
+			if root != test.root || mapping != test.mapping {
+				t.Errorf("got %s from mapping %q, want %s from %q", root, mapping, test.root, test.mapping)
+			}
+		})
+	}
+}
+
+func TestDecompressMappings(t *testing.T) {
+	destination, docs, photos := t.TempDir(), t.TempDir(), t.TempDir()
+	backupFile := writeZip(t, []testEntry{
+		manifestEntry(t,
+			ManifestSource{ID: "docs", Type: "folder", Path: "/home/user/docs"},
+			ManifestSource{ID: "photos", Type: "folder", Path: "/home/user/photos"},
+			ManifestSource{ID: "db", Type: "command"},
+		),
+		{name: "docs/a.txt", mode: 0644, content: "a"},
+		{name: "photos/b.jpg", mode: 0644, content: "b"},
+		{name: "db/dump.sql", mode: 0644, content: "dump"},
+	})
+
+	_, err := Decompress(context.Background(), backupFile, destination, RestoreOptions{
+		Mappings: map[string]string{"docs": docs, "/home/user/photos": photos},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for dir, want := range map[string]map[string]string{
+		docs:                             {"a.txt": "a"},
+		photos:                           {"b.jpg": "b"},
+		filepath.Join(destination, "db"): {"dump.sql": "dump"},
+	} {
+		files := readFiles(t, dir)
+		if !reflect.DeepEqual(files, want) {
+			t.Errorf("got files %v in %s, want %v", files, dir, want)
+		}
+	}
+	entries, _ := os.ReadDir(destination)
+	if len(entries) != 1 {
+		t.Errorf("mapped sources also restored to the destination: %v", entries)
+	}
+}
+
+func TestDecompressUnknownMapping(t *testing.T) {
+	destination, elsewhere := t.TempDir(), t.TempDir()
+	backupFile := writeZip(t, []testEntry{
+		manifestEntry(t, ManifestSource{ID: "docs", Type: "folder", Path: "/home/user/docs"}),
+		{name: "docs/a.txt", mode: 0644, content: "a"},
+	})
+
+	_, err := Decompress(context.Background(), backupFile, destination, RestoreOptions{
+		Mappings: map[string]string{"dcos": elsewhere},
+	})
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	assertEmpty(t, destination)
+	assertEmpty(t, elsewhere)
+}
+
+func TestDecompressToOriginal(t *testing.T) {
+	destination, original := t.TempDir(), t.TempDir()
+	backupFile := writeZip(t, []testEntry{
+		manifestEntry(t,
+			ManifestSource{ID: "docs", Type: "folder", Path: original},
+			ManifestSource{ID: "db", Type: "command"},
+		),
+		{name: "docs/", mode: fs.ModeDir | 0755},
+		{name: "docs/a.txt", mode: 0644, content: "a"},
+		{name: "db/dump.sql", mode: 0644, content: "dump"},
+	})
+
+	_, err := Decompress(context.Background(), backupFile, destination, RestoreOptions{ToOriginal: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := readFiles(t, original)
+	if !reflect.DeepEqual(files, map[string]string{"a.txt": "a"}) {
+		t.Errorf("got files %v in the original path", files)
+	}
+	// sources without a path are restored to the destination
+	files = readFiles(t, filepath.Join(destination, "db"))
+	if !reflect.DeepEqual(files, map[string]string{"dump.sql": "dump"}) {
+		t.Errorf("got files %v in the destination", files)
+	}
+}
+
+func TestDecompressToOriginalWithoutManifest(t *testing.T) {
+	destination := t.TempDir()
+	backupFile := writeZip(t, []testEntry{
+		{name: "docs/a.txt", mode: 0644, content: "a"},
+	})
+
+	_, err := Decompress(context.Background(), backupFile, destination, RestoreOptions{ToOriginal: true})
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	assertEmpty(t, destination)
+}
@@ -0,0 +1,152 @@
+package backup
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/rs/zerolog/log"
+)
+
+// workDirRoot is the directory the tool owns in the configured work dir, or in
+// the system temp dir, in which every work dir is created. Nothing outside of
+// it is ever swept.
+const workDirRoot = "backup"
+
+// workDirPrefix is the prefix of every directory created in the work dir root.
+// It is followed by the PID of the process which created the directory, which
+// is only informative: a directory is in use as long as it is locked.
+const workDirPrefix = "backup-"
+
+// workDirMarker is the file marking a directory as created by NewWorkDir, only
+// directories holding it are swept.
+const workDirMarker = ".backup-work-dir"
+
+// WorkDir is a directory created in the work dir, holding an flock on it for as
+// long as it is in use so that it isn't swept. The kernel releases the lock if
+// the process crashes, leaving the directory to be swept.
+type WorkDir struct {
+	Path string
+	file *os.File
+}
+
+// workDirRootPath returns the directory in which work dirs are created.
+func workDirRootPath(workDir string) string {
+	if workDir == "" {
+		workDir = os.TempDir()
+	}
+	return filepath.Join(workDir, workDirRoot)
+}
+
+// NewWorkDir creates a new directory in the backup directory of workDir, or of
+// the system temp dir if workDir is empty. The caller is responsible for
+// removing it.
+func NewWorkDir(workDir string) (*WorkDir, error) {
+	root := workDirRootPath(workDir)
+	err := os.MkdirAll(root, 0700)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		path, err := os.MkdirTemp(root, fmt.Sprintf("%s%d-*", workDirPrefix, os.Getpid()))
+		if err != nil {
+			return nil, err
+		}
+		file, err := lockDir(path)
+		if errors.Is(err, syscall.EWOULDBLOCK) || os.IsNotExist(err) {
+			// a sweep took the directory before it was locked, try another one
+			continue
+		}
+		if err != nil {
+			os.RemoveAll(path)
+			return nil, fmt.Errorf("failed to lock work dir: %w", err)
+		}
+		err = os.WriteFile(filepath.Join(path, workDirMarker), nil, 0600)
+		if err != nil {
+			file.Close()
+			os.RemoveAll(path)
+			return nil, fmt.Errorf("failed to mark work dir: %w", err)
+		}
+		return &WorkDir{Path: path, file: file}, nil
+	}
+}
+
+// Remove removes the directory and releases its lock.
+func (d *WorkDir) Remove() error {
+	err := os.RemoveAll(d.Path)
+	d.file.Close()
+	return err
+}
+
+// lockDir takes the lock of a directory without blocking. It fails with
+// os.ErrNotExist if the directory was removed, even after it was opened.
+func lockDir(path string) (*os.File, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	// the directory may have been swept between opening and locking it
+	info, err := os.Stat(path)
+	if err == nil {
+		var opened os.FileInfo
+		opened, err = file.Stat()
+		if err == nil && !os.SameFile(info, opened) {
+			err = os.ErrNotExist
+		}
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+// SweepWorkDir removes the work dirs left in workDir by runs which are not in
+// progress anymore, e.g. because their process crashed or was killed. Only
+// marked directories in the backup directory of workDir are removed.
+func SweepWorkDir(workDir string) error {
+	root := workDirRootPath(workDir)
+	entries, err := os.ReadDir(root)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read work dir: %w", err)
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() || !strings.HasPrefix(entry.Name(), workDirPrefix) {
+			continue
+		}
+		path := filepath.Join(root, entry.Name())
+		_, err := os.Lstat(filepath.Join(path, workDirMarker))
+		if err != nil {
+			// not created by NewWorkDir, or not marked yet
+			continue
+		}
+		file, err := lockDir(path)
+		if errors.Is(err, syscall.EWOULDBLOCK) || os.IsNotExist(err) {
+			// in use by a running process, or removed in the meantime
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to lock leftover %s: %w", path, err)
+		}
+
+		err = os.RemoveAll(path)
+		file.Close()
+		if err != nil {
+			return fmt.Errorf("failed to remove leftover %s: %w", path, err)
+		}
+		log.Info().Str("path", path).Msg("removed leftover from previous run")
+	}
+	return nil
+}
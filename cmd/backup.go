@@ -1,15 +1,12 @@
 package cmd
 
 import (
-	"fmt"
-	"os"
-	"os/signal"
-	"syscall"
-	"time"
+	"context"
 
+	"github.com/guillembonet/backup/api"
 	"github.com/guillembonet/backup/backup"
-	"github.com/guillembonet/backup/config"
-	"github.com/rs/zerolog"
+	"github.com/guillembonet/backup/daemon"
+	"github.com/guillembonet/backup/metrics"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/cobra"
 )
@@ -18,56 +15,64 @@ var backupCmd = &cobra.Command{
 	Use:   "backup",
 	Short: "Encrypt and backup your files",
 	Run: func(cmd *cobra.Command, args []string) {
-		configPath, err := cmd.Flags().GetString("config-path")
-		if err != nil {
-			log.Fatal().Err(err).Msg("no config path defined")
-		}
-		cfg, err := config.LoadConfig(configPath)
-		if err != nil {
-			log.Fatal().Err(err).Msg("failed to load config")
-		}
+		cfg := loadConfig(cmd)
 
-		if cfg.Runtime.LogLevel == "" {
-			cfg.Runtime.LogLevel = "debug"
-		}
-		logLevel, err := zerolog.ParseLevel(cfg.Runtime.LogLevel)
+		err := backup.SweepWorkDir(cfg.Runtime.WorkDir)
 		if err != nil {
-			log.Fatal().Err(err).Msg("failed to parse log level")
+			log.Fatal().Err(err).Msg("failed to sweep work dir")
 		}
-		log.Logger = log.Logger.Level(logLevel)
 
-		log.Info().Str("config", fmt.Sprintf("%+v", cfg)).Msg("loaded config")
-
-		backup, err := backup.New(cfg.Backup)
+		d, err := daemon.New(cfg)
 		if err != nil {
 			log.Fatal().Err(err).Msg("failed to create backup service")
 		}
 
-		err = backup.Run()
-		if err != nil {
-			if cfg.RunMode.RunOnceAndExit {
+		ctx := cmd.Context()
+		if cfg.RunMode.RunOnceAndExit {
+			err = d.RunAll(ctx)
+			if err != nil {
 				log.Fatal().Err(err).Msg("failed to run backup service")
 			}
-			log.Error().Err(err).Msg("failed to run backup service")
-		}
-		if cfg.RunMode.RunOnceAndExit {
-			os.Exit(0)
+			return
 		}
 
-		sigKill := make(chan os.Signal, 1)
-		signal.Notify(sigKill, os.Interrupt, syscall.SIGTERM)
-		for {
-			select {
-			case <-sigKill:
-				log.Info().Msg("received kill signal, exiting")
-				os.Exit(0)
-			case <-time.After(cfg.RunMode.Interval):
-				err = backup.Run()
-				if err != nil {
-					log.Error().Err(err).Msg("failed to run backup service")
-				}
+		// a server failing stops the daemon like a kill signal, letting the
+		// running backups finish
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		serveErrs := make(chan error, 2)
+		serve := func(serve func() error) {
+			err := serve()
+			if err != nil {
+				serveErrs <- err
+				cancel()
 			}
 		}
+
+		if cfg.Metrics.Listen != "" {
+			m := metrics.New()
+			d.AddObserver(m)
+			go serve(func() error {
+				return m.Serve(ctx, cfg.Metrics.Listen)
+			})
+		}
+
+		if cfg.API.Listen != "" {
+			go serve(func() error {
+				return api.New(d, cfg.API.Token).Serve(ctx, cfg.API.Listen)
+			})
+		}
+
+		err = d.Run(ctx)
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed to run backup service")
+		}
+		select {
+		case err := <-serveErrs:
+			log.Fatal().Err(err).Msg("stopped after a server failed")
+		default:
+		}
+		log.Info().Msg("received kill signal, exiting")
 	},
 }
 
@@ -0,0 +1,32 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/guillembonet/backup/backup"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+var catCmd = &cobra.Command{
+	Use:   "cat [encrypted file path] [path]",
+	Short: "Print a file stored in a backup",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		backupFile, password, cleanup, err := fetchBackup(cmd, args[0])
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed to fetch backup")
+		}
+		defer cleanup()
+
+		err = backup.Cat(backupFile, password, args[1], os.Stdout)
+		if err != nil {
+			cleanup()
+			log.Fatal().Err(err).Msg("failed to read file from backup")
+		}
+	},
+}
+
+func init() {
+	addFetchFlags(catCmd)
+}
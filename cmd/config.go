@@ -0,0 +1,54 @@
+package cmd
+
+import (
+	"github.com/guillembonet/backup/config"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+// loadConfig loads the config pointed by the config-path flag and sets up the
+// logger with it.
+func loadConfig(cmd *cobra.Command) *config.Config {
+	configPath, err := cmd.Flags().GetString("config-path")
+	if err != nil {
+		log.Fatal().Err(err).Msg("no config path defined")
+	}
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to load config")
+	}
+
+	if cfg.Runtime.LogLevel == "" {
+		cfg.Runtime.LogLevel = "debug"
+	}
+	logLevel, err := zerolog.ParseLevel(cfg.Runtime.LogLevel)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to parse log level")
+	}
+	log.Logger = log.Logger.Level(logLevel)
+
+	logConfig(cfg)
+	return cfg
+}
+
+// logConfig logs a summary of the jobs in the config, leaving out the settings
+// which may hold secrets like passwords or keys. Invalid jobs are reported
+// when they are used.
+func logConfig(cfg *config.Config) {
+	jobs, err := cfg.GetJobs()
+	if err != nil {
+		return
+	}
+	for _, job := range jobs {
+		sources := make([]string, len(job.Sources))
+		for i, source := range job.Sources {
+			sources[i] = source.Type
+		}
+		targets := make([]string, len(job.Targets))
+		for i, target := range job.Targets {
+			targets[i] = target.Type
+		}
+		log.Info().Str("job", job.Name).Strs("sources", sources).Strs("targets", targets).Msg("loaded config")
+	}
+}
@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/guillembonet/backup/backup"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+var diffCmd = &cobra.Command{
+	Use:   "diff [old encrypted file path] [new encrypted file path]",
+	Short: "Show the files changed between two backups",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		asJSON, err := cmd.Flags().GetBool("json")
+		if err != nil {
+			log.Fatal().Err(err).Msg("no json flag defined")
+		}
+
+		entries := make([][]backup.Entry, len(args))
+		for i, arg := range args {
+			backupFile, password, cleanup, err := fetchBackup(cmd, arg)
+			if err != nil {
+				log.Fatal().Err(err).Msg("failed to fetch backup")
+			}
+			entries[i], err = backup.List(backupFile, password, "")
+			cleanup()
+			if err != nil {
+				log.Fatal().Err(err).Str("backup", arg).Msg("failed to list backup")
+			}
+		}
+
+		report := backup.Diff(entries[0], entries[1])
+		if asJSON {
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			err = encoder.Encode(report)
+			if err != nil {
+				log.Fatal().Err(err).Msg("failed to encode report")
+			}
+			return
+		}
+
+		for _, entry := range report.Added {
+			fmt.Printf("+ %s (%+d bytes)\n", entry.Name, entry.SizeDelta)
+		}
+		for _, entry := range report.Removed {
+			fmt.Printf("- %s (%+d bytes)\n", entry.Name, entry.SizeDelta)
+		}
+		for _, entry := range report.Modified {
+			fmt.Printf("~ %s (%d -> %d, %+d bytes)\n", entry.Name, entry.OldSize, entry.NewSize, entry.SizeDelta)
+		}
+		fmt.Printf("%d added, %d removed, %d modified, total size %d -> %d (%+d bytes)\n",
+			len(report.Added), len(report.Removed), len(report.Modified),
+			report.OldSize, report.NewSize, report.SizeDelta)
+	},
+}
+
+func init() {
+	addFetchFlags(diffCmd)
+	diffCmd.Flags().Bool("json", false, "print the report as JSON")
+}
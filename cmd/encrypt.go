@@ -1,11 +1,7 @@
 package cmd
 
 import (
-	"fmt"
-
 	"github.com/guillembonet/backup/backup"
-	"github.com/guillembonet/backup/config"
-	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/cobra"
 )
@@ -14,27 +10,17 @@ var encryptCmd = &cobra.Command{
 	Use:   "encrypt",
 	Short: "Encrypt your files",
 	Run: func(cmd *cobra.Command, args []string) {
-		configPath, err := cmd.Flags().GetString("config-path")
-		if err != nil {
-			log.Fatal().Err(err).Msg("no config path defined")
-		}
-		cfg, err := config.LoadConfig(configPath)
+		cfg := loadConfig(cmd)
+		jobName, err := cmd.Flags().GetString("job")
 		if err != nil {
-			log.Fatal().Err(err).Msg("failed to load config")
+			log.Fatal().Err(err).Msg("no job defined")
 		}
-
-		if cfg.Runtime.LogLevel == "" {
-			cfg.Runtime.LogLevel = "debug"
-		}
-		logLevel, err := zerolog.ParseLevel(cfg.Runtime.LogLevel)
+		job, err := cfg.GetJob(jobName)
 		if err != nil {
-			log.Fatal().Err(err).Msg("failed to parse log level")
+			log.Fatal().Err(err).Msg("failed to find job")
 		}
-		log.Logger = log.Logger.Level(logLevel)
-
-		log.Info().Str("config", fmt.Sprintf("%+v", cfg)).Msg("loaded config")
 
-		backup, err := backup.New(cfg.Backup)
+		backup, err := backup.New(job, cfg.Runtime.WorkDir)
 		if err != nil {
 			log.Fatal().Err(err).Msg("failed to create backup service")
 		}
@@ -44,18 +30,24 @@ var encryptCmd = &cobra.Command{
 			log.Fatal().Err(err).Msg("no output path defined")
 		}
 
-		err = backup.Encrypt(outputPath)
+		report, err := backup.Encrypt(cmd.Context(), outputPath)
 		if err != nil {
 			log.Fatal().Err(err).Msg("failed to encrypt files")
 		}
 
 		log.Info().
 			Str("output_path", outputPath).
+			Int("files", report.FilesProcessed).
+			Int("skipped", len(report.Skipped)).
+			Int("flagged", len(report.Flagged)).
+			Int64("bytes_read", report.BytesRead).
+			Int64("size", report.ArchiveSize).
 			Msg("successfully encrypted files")
 	},
 }
 
 func init() {
 	encryptCmd.Flags().StringP("config-path", "c", "./example_config.yaml", "config file path")
+	encryptCmd.Flags().StringP("job", "j", "", "name of the job to encrypt, can be empty if there is a single job")
 	encryptCmd.Flags().StringP("output-path", "o", "./backup.bin", "output file path of resulting encrypted file")
 }
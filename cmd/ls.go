@@ -0,0 +1,45 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/guillembonet/backup/backup"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+var lsCmd = &cobra.Command{
+	Use:   "ls [encrypted file path] [path]",
+	Short: "List the contents of a backup",
+	Args:  cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		backupFile, password, cleanup, err := fetchBackup(cmd, args[0])
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed to fetch backup")
+		}
+		defer cleanup()
+
+		prefix := ""
+		if len(args) > 1 {
+			prefix = args[1]
+		}
+		entries, err := backup.List(backupFile, password, prefix)
+		if err != nil {
+			cleanup()
+			log.Fatal().Err(err).Msg("failed to list backup")
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		for _, entry := range entries {
+			fmt.Fprintf(w, "%s\t%d\t%s\t%s\n", entry.Mode, entry.Size, entry.ModTime.Format(time.RFC3339), entry.Name)
+		}
+		w.Flush()
+	},
+}
+
+func init() {
+	addFetchFlags(lsCmd)
+}
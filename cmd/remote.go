@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/guillembonet/backup/backup"
+	"github.com/guillembonet/backup/config"
+	"github.com/spf13/cobra"
+)
+
+// addFetchFlags registers the flags used by commands which read a backup
+// either from the local filesystem or from a configured target.
+func addFetchFlags(cmd *cobra.Command) {
+	cmd.Flags().StringP("password", "p", "", "password for encryption/decryption, defaults to the configured one when using --target")
+	cmd.Flags().StringP("target", "t", "", "name or type of the target to fetch the backup from instead of the local filesystem")
+	cmd.Flags().StringP("config-path", "c", "./example_config.yaml", "config file path, used with --target")
+	cmd.Flags().StringP("job", "j", "", "name of the job the target belongs to, used with --target")
+}
+
+// fetchBackup returns a local path to the given backup together with the
+// password to decrypt it. If a target was requested the backup is downloaded
+// to a temporary directory which is removed by the returned cleanup function.
+func fetchBackup(cmd *cobra.Command, backupFile string) (string, string, func(), error) {
+	password, err := cmd.Flags().GetString("password")
+	if err != nil {
+		return "", "", nil, fmt.Errorf("no password defined: %w", err)
+	}
+	targetName, err := cmd.Flags().GetString("target")
+	if err != nil {
+		return "", "", nil, fmt.Errorf("no target defined: %w", err)
+	}
+	if targetName == "" {
+		return backupFile, password, func() {}, nil
+	}
+
+	configPath, err := cmd.Flags().GetString("config-path")
+	if err != nil {
+		return "", "", nil, fmt.Errorf("no config path defined: %w", err)
+	}
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		return "", "", nil, fmt.Errorf("failed to load config: %w", err)
+	}
+	jobName, err := cmd.Flags().GetString("job")
+	if err != nil {
+		return "", "", nil, fmt.Errorf("no job defined: %w", err)
+	}
+	job, err := cfg.GetJob(jobName)
+	if err != nil {
+		return "", "", nil, fmt.Errorf("failed to find job: %w", err)
+	}
+	if password == "" {
+		password = job.EncryptionPassword
+	}
+
+	b, err := backup.New(job, cfg.Runtime.WorkDir)
+	if err != nil {
+		return "", "", nil, fmt.Errorf("failed to create backup service: %w", err)
+	}
+
+	downloadDir, err := backup.NewWorkDir(cfg.Runtime.WorkDir)
+	if err != nil {
+		return "", "", nil, fmt.Errorf("failed to create download directory: %w", err)
+	}
+	cleanup := func() { downloadDir.Remove() }
+
+	localFile := filepath.Join(downloadDir.Path, filepath.Base(backupFile))
+	err = b.Download(cmd.Context(), targetName, filepath.Base(backupFile), localFile)
+	if err != nil {
+		cleanup()
+		return "", "", nil, fmt.Errorf("failed to download backup: %w", err)
+	}
+	return localFile, password, cleanup, nil
+}
@@ -1,6 +1,9 @@
 package cmd
 
 import (
+	"fmt"
+	"strings"
+
 	"github.com/guillembonet/backup/backup"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/cobra"
@@ -19,17 +22,112 @@ var restoreCmd = &cobra.Command{
 		if err != nil {
 			log.Fatal().Err(err).Msg("no password defined")
 		}
+		strict, err := cmd.Flags().GetBool("strict")
+		if err != nil {
+			log.Fatal().Err(err).Msg("no strict flag defined")
+		}
+		onConflict, err := cmd.Flags().GetString("on-conflict")
+		if err != nil {
+			log.Fatal().Err(err).Msg("no conflict policy defined")
+		}
+		dryRun, err := cmd.Flags().GetBool("dry-run")
+		if err != nil {
+			log.Fatal().Err(err).Msg("no dry run flag defined")
+		}
+		toOriginal, err := cmd.Flags().GetBool("to-original")
+		if err != nil {
+			log.Fatal().Err(err).Msg("no to original flag defined")
+		}
+		mapFlags, err := cmd.Flags().GetStringArray("map")
+		if err != nil {
+			log.Fatal().Err(err).Msg("no map flag defined")
+		}
+		mappings := map[string]string{}
+		for _, mapping := range mapFlags {
+			oldPath, newPath, ok := strings.Cut(mapping, "=")
+			if !ok || oldPath == "" || newPath == "" {
+				log.Fatal().Str("map", mapping).Msg("invalid mapping, expected old=new")
+			}
+			mappings[oldPath] = newPath
+		}
 
 		encryptedFilePath := args[0]
-		err = backup.Restore(encryptedFilePath, outputDir, password)
+		sourceType, err := cmd.Flags().GetString("source")
+		if err != nil {
+			log.Fatal().Err(err).Msg("no source flag defined")
+		}
+		if sourceType != "" {
+			restoreSources(cmd, encryptedFilePath, outputDir, password, sourceType, dryRun)
+			return
+		}
+
+		report, err := backup.Restore(cmd.Context(), encryptedFilePath, outputDir, password, backup.RestoreOptions{
+			Strict:     strict,
+			OnConflict: backup.ConflictPolicy(onConflict),
+			DryRun:     dryRun,
+			ToOriginal: toOriginal,
+			Mappings:   mappings,
+		})
 		if err != nil {
 			log.Fatal().Err(err).Msg("failed to restore")
 		}
-		log.Info().Msg("restored")
+		if dryRun {
+			for _, action := range report.Actions {
+				fmt.Printf("%s\t%s\n", action.Action, action.Path)
+			}
+		}
+		for _, rejected := range report.Rejected {
+			log.Warn().Str("name", rejected.Name).Str("reason", rejected.Reason).Msg("entry not restored")
+		}
+		log.Info().
+			Bool("dry_run", dryRun).
+			Int("created", report.Count(backup.ActionCreate)).
+			Int("overwritten", report.Count(backup.ActionOverwrite)).
+			Int("renamed", report.Count(backup.ActionRename)).
+			Int("skipped", report.Count(backup.ActionSkip)).
+			Int("rejected", len(report.Rejected)).
+			Msg("restored")
 	},
 }
 
+// restoreSources restores the sources of a type with their own tools instead
+// of extracting their files.
+func restoreSources(cmd *cobra.Command, encryptedFilePath string, outputDir string, password string, sourceType string, dryRun bool) {
+	connectionString, err := cmd.Flags().GetString("connection-string")
+	if err != nil {
+		log.Fatal().Err(err).Msg("no connection string flag defined")
+	}
+	clean, err := cmd.Flags().GetBool("clean")
+	if err != nil {
+		log.Fatal().Err(err).Msg("no clean flag defined")
+	}
+
+	restored, err := backup.RestoreSources(cmd.Context(), encryptedFilePath, password, sourceType, backup.SourceRestoreOptions{
+		ConnectionString: connectionString,
+		Clean:            clean,
+		Destination:      outputDir,
+		DryRun:           dryRun,
+	})
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to restore sources")
+	}
+	if dryRun {
+		for _, name := range restored {
+			fmt.Println(name)
+		}
+	}
+	log.Info().Bool("dry_run", dryRun).Int("restored", len(restored)).Msg("restored sources")
+}
+
 func init() {
 	restoreCmd.Flags().StringP("output", "o", "./", "output directory")
 	restoreCmd.Flags().StringP("password", "p", "", "password for encryption/decryption")
+	restoreCmd.Flags().String("on-conflict", string(backup.ConflictOverwrite), "what to do with existing files: skip, overwrite, rename or if-newer")
+	restoreCmd.Flags().Bool("dry-run", false, "only list what would be written")
+	restoreCmd.Flags().Bool("to-original", false, "restore every source to the path it was backed up from")
+	restoreCmd.Flags().StringArray("map", nil, "restore the source with the given id or original path somewhere else, as old=new")
+	restoreCmd.Flags().Bool("strict", false, "abort on the first unsafe archive entry instead of skipping it")
+	restoreCmd.Flags().String("source", "", "restore the sources of this type with their own tool instead of extracting files: postgres restores the databases, git clones the repositories into the output directory")
+	restoreCmd.Flags().String("connection-string", "", "with --source postgres, connection string to a database of the server to restore to, e.g. postgres")
+	restoreCmd.Flags().Bool("clean", false, "with --source postgres, drop the databases which already exist before restoring them")
 }
@@ -1,6 +1,13 @@
 package cmd
 
-import "github.com/spf13/cobra"
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/spf13/cobra"
+)
 
 var rootCmd = &cobra.Command{
 	Use:   "backup",
@@ -12,8 +19,15 @@ func init() {
 	rootCmd.AddCommand(restoreCmd)
 	rootCmd.AddCommand(encryptCmd)
 	rootCmd.AddCommand(backupCmd)
+	rootCmd.AddCommand(runCmd)
+	rootCmd.AddCommand(lsCmd)
+	rootCmd.AddCommand(catCmd)
+	rootCmd.AddCommand(diffCmd)
 }
 
 func Execute() error {
-	return rootCmd.Execute()
+	// cancel the running command on interrupt so that it can clean up before exiting
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return rootCmd.ExecuteContext(ctx)
 }
@@ -0,0 +1,44 @@
+package cmd
+
+import (
+	"github.com/guillembonet/backup/backup"
+	"github.com/guillembonet/backup/daemon"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+var runCmd = &cobra.Command{
+	Use:   "run",
+	Short: "Run backup jobs once, ignoring their schedule",
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg := loadConfig(cmd)
+		jobName, err := cmd.Flags().GetString("job")
+		if err != nil {
+			log.Fatal().Err(err).Msg("no job defined")
+		}
+
+		err = backup.SweepWorkDir(cfg.Runtime.WorkDir)
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed to sweep work dir")
+		}
+
+		d, err := daemon.New(cfg)
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed to create backup service")
+		}
+
+		if jobName == "" {
+			err = d.RunAll(cmd.Context())
+		} else {
+			err = d.RunJob(cmd.Context(), jobName)
+		}
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed to run backup")
+		}
+	},
+}
+
+func init() {
+	runCmd.Flags().StringP("config-path", "c", "./example_config.yaml", "config file path")
+	runCmd.Flags().StringP("job", "j", "", "name of the job to run, all jobs run if empty")
+}
@@ -3,6 +3,10 @@ package config
 import (
 	"fmt"
 	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
 	"time"
 
 	"gopkg.in/yaml.v2"
@@ -12,34 +16,177 @@ type Config struct {
 	Runtime Runtime `yaml:"runtime"`
 	RunMode RunMode `yaml:"run_mode"`
 	Backup  Backup  `yaml:"backup"`
+	Jobs    []Job   `yaml:"jobs"`
+	Metrics Metrics `yaml:"metrics"`
+	API     API     `yaml:"api"`
+
+	Notifications []Notification `yaml:"notifications"`
+}
+
+// Notification configures where to send the outcome of the runs of every job
+// and on which conditions. A recovery is a successful run after a failed one.
+type Notification struct {
+	Type       string            `yaml:"type"`
+	OnFailure  bool              `yaml:"on_failure"`
+	OnSuccess  bool              `yaml:"on_success"`
+	OnRecovery bool              `yaml:"on_recovery"`
+	Config     map[string]string `yaml:"config"`
+}
+
+// API configures the HTTP control API of the daemon, which is disabled if
+// Listen is empty. Requests must carry Token as a bearer token if it is set.
+type API struct {
+	Listen string `yaml:"listen"`
+	Token  string `yaml:"token"`
+}
+
+// Metrics configures the Prometheus endpoint of the daemon, which is disabled
+// if Listen is empty.
+type Metrics struct {
+	Listen string `yaml:"listen"`
 }
 
 type Runtime struct {
 	LogLevel string `yaml:"log_level"`
+	WorkDir  string `yaml:"work_dir"`
+	// StateDir holds what has to survive restarts, like when each job last
+	// ran, see GetStateDir.
+	StateDir string `yaml:"state_dir"`
+	OnLocked string `yaml:"on_locked"`
+}
+
+// GetStateDir returns StateDir, defaulting to backup in $XDG_STATE_HOME or in
+// ~/.local/state.
+func (r Runtime) GetStateDir() (string, error) {
+	if r.StateDir != "" {
+		return r.StateDir, nil
+	}
+	if stateHome := os.Getenv("XDG_STATE_HOME"); stateHome != "" {
+		return filepath.Join(stateHome, "backup"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to find state dir: %w", err)
+	}
+	return filepath.Join(home, ".local", "state", "backup"), nil
 }
 
 type RunMode struct {
 	RunOnceAndExit bool          `yaml:"run_once_and_exit"`
 	Interval       time.Duration `yaml:"interval"`
+	Schedule       string        `yaml:"schedule"`
+	Timezone       string        `yaml:"timezone"`
+	Jitter         time.Duration `yaml:"jitter"`
+}
+
+// Job is a named backup with its own schedule. Unset run mode fields are
+// inherited from the top level run mode.
+type Job struct {
+	Name    string  `yaml:"name"`
+	RunMode RunMode `yaml:"run_mode"`
+	Backup  `yaml:",inline"`
 }
 
+// DefaultJobName is the name of the job created from the top level backup
+// block when no jobs are configured.
+const DefaultJobName = "default"
+
+var jobNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9_.-]+$`)
+
 type Backup struct {
-	EncryptionPassword string   `yaml:"encryption_password"`
-	Sources            []Source `yaml:"sources"`
-	Targets            []Target `yaml:"targets"`
+	EncryptionPassword string      `yaml:"encryption_password"`
+	Sources            []Source    `yaml:"sources"`
+	Targets            []Target    `yaml:"targets"`
+	Healthcheck        Healthcheck `yaml:"healthcheck"`
+	Hooks              Hooks       `yaml:"hooks"`
+}
+
+// Hooks are shell commands run around a job or a source. A failing pre hook
+// aborts the run, on_error hooks run when it fails and post hooks always run
+// at the end.
+type Hooks struct {
+	Pre     []string      `yaml:"pre"`
+	Post    []string      `yaml:"post"`
+	OnError []string      `yaml:"on_error"`
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// Healthcheck holds the URLs pinged around every run, as used by
+// healthchecks.io and alike.
+type Healthcheck struct {
+	StartURL   string `yaml:"start_url"`
+	SuccessURL string `yaml:"success_url"`
+	FailURL    string `yaml:"fail_url"`
 }
 
 type Source struct {
+	ID   string `yaml:"id"`
 	Type string `yaml:"type"`
 	Path string `yaml:"path"`
+	// Paths lists more paths for sources which take several, e.g. the
+	// database files of sqlite sources.
+	Paths []string `yaml:"paths"`
+	// Include and Exclude are glob patterns selecting the files of folder and
+	// sftp sources.
+	Include []string `yaml:"include"`
+	Exclude []string `yaml:"exclude"`
+	// OnError is what folder sources do with files which cannot be read:
+	// fail, warn or skip.
+	OnError string `yaml:"on_error"`
+	// FollowSymlinks, OneFileSystem and SkipSpecialFiles control how folder
+	// sources handle symlinks, mount points and special files.
+	FollowSymlinks   bool `yaml:"follow_symlinks"`
+	OneFileSystem    bool `yaml:"one_file_system"`
+	SkipSpecialFiles bool `yaml:"skip_special_files"`
+	// IncludeCaches makes folder sources back up directories with a
+	// CACHEDIR.TAG file.
+	IncludeCaches bool `yaml:"include_caches"`
+	// Command and FileName configure command sources, whose output is stored
+	// in the archive as a file named FileName.
+	Command  string `yaml:"command"`
+	FileName string `yaml:"file_name"`
+	// ConnectionString and Databases configure postgres sources, all the
+	// databases are dumped if none is listed.
+	ConnectionString string   `yaml:"connection_string"`
+	Databases        []string `yaml:"databases"`
+	// Config holds the settings of sources which connect to a remote server.
+	Config map[string]string `yaml:"config"`
+	Hooks  Hooks             `yaml:"hooks"`
+}
+
+// GetPaths returns Path followed by Paths.
+func (s Source) GetPaths() []string {
+	paths := []string{}
+	if s.Path != "" {
+		paths = append(paths, s.Path)
+	}
+	return append(paths, s.Paths...)
 }
 
 type Target struct {
+	Name                 string            `yaml:"name"`
 	Type                 string            `yaml:"type"`
 	BackupExpirationDays int               `yaml:"backup_expiration_days"`
 	Config               map[string]string `yaml:"config"`
 }
 
+// location identifies where the target stores backups: targets of the same
+// type with the same settings, credentials aside, share their backups.
+func (t Target) location() string {
+	keys := make([]string, 0, len(t.Config))
+	for key := range t.Config {
+		if key != "password" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	location := t.Type
+	for _, key := range keys {
+		location += fmt.Sprintf("\x00%s=%s", key, t.Config[key])
+	}
+	return location
+}
+
 func LoadConfig(configPath string) (*Config, error) {
 	data, err := os.ReadFile(configPath)
 	if err != nil {
@@ -51,5 +198,83 @@ func LoadConfig(configPath string) (*Config, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to unmarshal config file: %w", err)
 	}
+
+	_, err = config.GetJobs()
+	if err != nil {
+		return nil, fmt.Errorf("invalid jobs: %w", err)
+	}
 	return &config, nil
 }
+
+// GetJobs returns the configured jobs. A config without jobs is treated as a
+// single job named DefaultJobName made of the top level backup block.
+func (c *Config) GetJobs() ([]Job, error) {
+	if len(c.Jobs) == 0 {
+		return []Job{{
+			Name:    DefaultJobName,
+			RunMode: c.RunMode,
+			Backup:  c.Backup,
+		}}, nil
+	}
+
+	if !reflect.DeepEqual(c.Backup, Backup{}) {
+		return nil, fmt.Errorf("the top level backup block cannot be used together with jobs")
+	}
+
+	jobs := make([]Job, len(c.Jobs))
+	names := map[string]bool{}
+	// locations maps where targets store backups to the job using them, as the
+	// expired backups of a location are cleaned whichever job uploaded them
+	locations := map[string]string{}
+	for i, job := range c.Jobs {
+		if !jobNameRegexp.MatchString(job.Name) {
+			return nil, fmt.Errorf("invalid job name: %q", job.Name)
+		}
+		if names[job.Name] {
+			return nil, fmt.Errorf("duplicated job name: %s", job.Name)
+		}
+		names[job.Name] = true
+		for _, target := range job.Targets {
+			location := target.location()
+			if other, ok := locations[location]; ok && other != job.Name {
+				return nil, fmt.Errorf("jobs %s and %s use the same %s target location", other, job.Name, target.Type)
+			}
+			locations[location] = job.Name
+		}
+
+		if job.RunMode.Schedule == "" && job.RunMode.Interval == 0 {
+			job.RunMode.Schedule = c.RunMode.Schedule
+			job.RunMode.Interval = c.RunMode.Interval
+		}
+		if job.RunMode.Timezone == "" {
+			job.RunMode.Timezone = c.RunMode.Timezone
+		}
+		if job.RunMode.Jitter == 0 {
+			job.RunMode.Jitter = c.RunMode.Jitter
+		}
+		job.RunMode.RunOnceAndExit = c.RunMode.RunOnceAndExit
+		jobs[i] = job
+	}
+	return jobs, nil
+}
+
+// GetJob returns the job with the given name. The name can be left empty if
+// there is a single job.
+func (c *Config) GetJob(name string) (Job, error) {
+	jobs, err := c.GetJobs()
+	if err != nil {
+		return Job{}, err
+	}
+	if name == "" {
+		if len(jobs) != 1 {
+			return Job{}, fmt.Errorf("there are %d jobs configured, a job name is required", len(jobs))
+		}
+		return jobs[0], nil
+	}
+	for _, job := range jobs {
+		if job.Name == name {
+			return job, nil
+		}
+	}
+	return Job{}, fmt.Errorf("unknown job: %s", name)
+}
@@ -0,0 +1,297 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/guillembonet/backup/backup"
+	"github.com/guillembonet/backup/config"
+	"github.com/guillembonet/backup/lock"
+	"github.com/guillembonet/backup/schedule"
+	"github.com/rs/zerolog/log"
+)
+
+// Behaviours when a run finds its job locked by another run.
+const (
+	OnLockedSkip = "skip"
+	OnLockedWait = "wait"
+	OnLockedFail = "fail"
+)
+
+// ErrSkipped is returned when a run is skipped because another run of the same
+// job is in progress.
+var ErrSkipped = errors.New("skipped, another run of the job is in progress")
+
+// lockRetryInterval is how often a locked job is retried when waiting for it.
+const lockRetryInterval = 5 * time.Second
+
+// Observer is notified of the outcome of every run of a job.
+type Observer interface {
+	Observe(job string, report *backup.Report, err error)
+}
+
+// Daemon runs the configured backup jobs, each one on its own schedule.
+type Daemon struct {
+	jobs      []*job
+	onLocked  string
+	observers []Observer
+	notifiers []notifier
+	// triggered tracks the runs started by Trigger
+	triggered sync.WaitGroup
+}
+
+type job struct {
+	cfg         config.Job
+	backup      *backup.Backup
+	lastRunPath string
+	outcomePath string
+	lockPath    string
+	// mu prevents overlapping runs of the job within the process
+	mu sync.Mutex
+	// failed is whether the last run failed, persisted in outcomePath so that
+	// recoveries are noticed across restarts. It is guarded by mu.
+	failed bool
+
+	statusMu sync.Mutex
+	status   JobStatus
+}
+
+func New(cfg *config.Config) (*Daemon, error) {
+	jobCfgs, err := cfg.GetJobs()
+	if err != nil {
+		return nil, err
+	}
+
+	workDir := cfg.Runtime.WorkDir
+	if workDir == "" {
+		workDir = os.TempDir()
+	}
+	err = os.MkdirAll(workDir, 0700)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create work dir: %w", err)
+	}
+
+	stateDir, err := cfg.Runtime.GetStateDir()
+	if err != nil {
+		return nil, err
+	}
+	err = os.MkdirAll(stateDir, 0700)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create state dir: %w", err)
+	}
+
+	onLocked := cfg.Runtime.OnLocked
+	switch onLocked {
+	case "":
+		onLocked = OnLockedSkip
+	case OnLockedSkip, OnLockedWait, OnLockedFail:
+	default:
+		return nil, fmt.Errorf("unknown on_locked behaviour: %s", onLocked)
+	}
+
+	notifiers, err := newNotifiers(cfg.Notifications)
+	if err != nil {
+		return nil, err
+	}
+
+	jobs := make([]*job, len(jobCfgs))
+	for i, jobCfg := range jobCfgs {
+		b, err := backup.New(jobCfg, cfg.Runtime.WorkDir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create backup service for job %s: %w", jobCfg.Name, err)
+		}
+		outcomePath := filepath.Join(stateDir, "last_outcome_"+jobCfg.Name)
+		failed, err := loadFailed(outcomePath)
+		if err != nil {
+			log.Error().Err(err).Str("job", jobCfg.Name).Msg("failed to load last outcome")
+		}
+		jobs[i] = &job{
+			cfg:         jobCfg,
+			backup:      b,
+			lastRunPath: filepath.Join(stateDir, "last_run_"+jobCfg.Name),
+			outcomePath: outcomePath,
+			lockPath:    filepath.Join(workDir, jobCfg.Name+".lock"),
+			failed:      failed,
+			status: JobStatus{
+				Name: jobCfg.Name,
+			},
+		}
+	}
+	return &Daemon{
+		jobs:      jobs,
+		onLocked:  onLocked,
+		notifiers: notifiers,
+	}, nil
+}
+
+// AddObserver registers an observer for the runs of every job. It must be
+// called before any job runs.
+func (d *Daemon) AddObserver(o Observer) {
+	d.observers = append(d.observers, o)
+}
+
+// Run runs every job on its schedule until ctx is cancelled, returning once
+// the runs in progress, including triggered ones, are done.
+func (d *Daemon) Run(ctx context.Context) error {
+	schedules := make([]*schedule.Schedule, len(d.jobs))
+	for i, j := range d.jobs {
+		s, err := schedule.New(j.cfg.RunMode)
+		if err != nil {
+			return fmt.Errorf("failed to create schedule for job %s: %w", j.cfg.Name, err)
+		}
+		schedules[i] = s
+	}
+
+	var wg sync.WaitGroup
+	for i, j := range d.jobs {
+		wg.Add(1)
+		go func(j *job, s *schedule.Schedule) {
+			defer wg.Done()
+			d.schedule(ctx, j, s)
+		}(j, schedules[i])
+	}
+	wg.Wait()
+	d.triggered.Wait()
+	return nil
+}
+
+// RunJob runs the job with the given name once.
+func (d *Daemon) RunJob(ctx context.Context, name string) error {
+	for _, j := range d.jobs {
+		if j.cfg.Name == name {
+			return d.run(ctx, j)
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrUnknownJob, name)
+}
+
+// RunAll runs every job once, one after the other.
+func (d *Daemon) RunAll(ctx context.Context) error {
+	var errs []error
+	for _, j := range d.jobs {
+		err := d.run(ctx, j)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("job %s: %w", j.cfg.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (d *Daemon) schedule(ctx context.Context, j *job, s *schedule.Schedule) {
+	logger := log.With().Str("job", j.cfg.Name).Logger()
+
+	// only successful runs are persisted, so that a failed or skipped run is
+	// retried after a restart, but the next run is scheduled after the last
+	// attempt so that a failing job isn't retried in a loop
+	lastRun, err := schedule.LoadLastRun(j.lastRunPath)
+	if err != nil {
+		logger.Error().Err(err).Msg("failed to load last run, running now")
+	}
+	lastAttempt := lastRun
+
+	for {
+		// runs missed while the daemon was down are due immediately
+		next := s.Next(lastAttempt)
+		j.setNextRun(next)
+		logger.Info().Time("last_run", lastRun).Time("next_run", next).Msg("scheduled next backup")
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Until(next)):
+			err = d.run(ctx, j)
+			if errors.Is(err, ErrSkipped) {
+				logger.Warn().Msg("backup skipped, another run is in progress")
+			} else if err != nil {
+				logger.Error().Err(err).Msg("failed to run backup")
+			}
+			if ctx.Err() != nil {
+				continue
+			}
+			lastAttempt = time.Now()
+			if err != nil {
+				continue
+			}
+			lastRun = lastAttempt
+			err = schedule.SaveLastRun(j.lastRunPath, lastRun)
+			if err != nil {
+				logger.Error().Err(err).Msg("failed to save last run")
+			}
+		}
+	}
+}
+
+func (d *Daemon) run(ctx context.Context, j *job) error {
+	release, err := d.lock(ctx, j)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	log.Info().Str("job", j.cfg.Name).Msg("running backup")
+	j.setRunning()
+	report, err := j.backup.Run(ctx)
+	j.setFinished(report, err)
+	for _, o := range d.observers {
+		o.Observe(j.cfg.Name, report, err)
+	}
+	d.notify(j, j.failed, report, err)
+	j.failed = err != nil
+	saveErr := saveFailed(j.outcomePath, j.failed)
+	if saveErr != nil {
+		log.Error().Err(saveErr).Str("job", j.cfg.Name).Msg("failed to save last outcome")
+	}
+	if err != nil {
+		return err
+	}
+	log.Info().Str("job", j.cfg.Name).
+		Dur("duration", report.Duration()).
+		Int("files", report.FilesProcessed).
+		Int("skipped", len(report.Skipped)).
+		Int("flagged", len(report.Flagged)).
+		Int64("size", report.ArchiveSize).
+		Msg("backup finished")
+	return nil
+}
+
+// lock takes both the in-process and the lock file of a job, handling a held
+// lock according to the on_locked behaviour. The returned function releases
+// both locks.
+func (d *Daemon) lock(ctx context.Context, j *job) (func(), error) {
+	for {
+		if j.mu.TryLock() {
+			fileLock, err := lock.Acquire(j.lockPath)
+			if err == nil {
+				return func() {
+					err := fileLock.Release()
+					if err != nil {
+						log.Error().Err(err).Str("job", j.cfg.Name).Msg("failed to release lock")
+					}
+					j.mu.Unlock()
+				}, nil
+			}
+			j.mu.Unlock()
+			if !errors.Is(err, lock.ErrLocked) {
+				return nil, err
+			}
+		}
+
+		switch d.onLocked {
+		case OnLockedSkip:
+			return nil, ErrSkipped
+		case OnLockedFail:
+			return nil, fmt.Errorf("job %s is locked by another run", j.cfg.Name)
+		}
+
+		log.Info().Str("job", j.cfg.Name).Msg("waiting for another run to finish")
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(lockRetryInterval):
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/guillembonet/backup/backup"
+	"github.com/guillembonet/backup/config"
+	"github.com/guillembonet/backup/notifications"
+	"github.com/guillembonet/backup/notifications/smtp"
+	"github.com/guillembonet/backup/notifications/webhook"
+	"github.com/rs/zerolog/log"
+)
+
+// Outcomes of a run persisted in the state dir.
+const (
+	outcomeSuccess = "success"
+	outcomeFailure = "failure"
+)
+
+// notifyTimeout bounds the time spent sending a notification.
+const notifyTimeout = time.Minute
+
+type notifier struct {
+	cfg      config.Notification
+	notifier notifications.Notifier
+}
+
+func newNotifiers(cfgs []config.Notification) ([]notifier, error) {
+	notifiers := make([]notifier, len(cfgs))
+	for i, cfg := range cfgs {
+		var n notifications.Notifier
+		var err error
+		switch cfg.Type {
+		case "webhook":
+			n, err = webhook.NewNotifier(cfg.Config)
+		case "smtp":
+			n, err = smtp.NewNotifier(cfg.Config)
+		default:
+			return nil, fmt.Errorf("unknown notification type: %s", cfg.Type)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to create %s notification: %w", cfg.Type, err)
+		}
+		notifiers[i] = notifier{
+			cfg:      cfg,
+			notifier: n,
+		}
+	}
+	return notifiers, nil
+}
+
+// wants reports whether the notifier has to be notified of a run with the
+// given status. A recovery is also a success.
+func (n notifier) wants(status string) bool {
+	switch status {
+	case notifications.StatusFailure:
+		return n.cfg.OnFailure
+	case notifications.StatusRecovery:
+		return n.cfg.OnRecovery || n.cfg.OnSuccess
+	default:
+		return n.cfg.OnSuccess
+	}
+}
+
+// notify sends the outcome of a run of a job to the interested notifiers.
+// Failures are only logged so that they don't affect the run.
+func (d *Daemon) notify(j *job, failedBefore bool, report *backup.Report, err error) {
+	event := notifications.Event{
+		Job:             j.cfg.Name,
+		Status:          notifications.StatusSuccess,
+		DurationSeconds: report.Duration().Seconds(),
+		Report:          report,
+	}
+	if err != nil {
+		event.Status = notifications.StatusFailure
+		event.Error = err.Error()
+	} else if failedBefore {
+		event.Status = notifications.StatusRecovery
+	}
+
+	for _, n := range d.notifiers {
+		if !n.wants(event.Status) {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+		notifyErr := n.notifier.Notify(ctx, event)
+		cancel()
+		if notifyErr != nil {
+			log.Error().Err(notifyErr).Str("job", j.cfg.Name).Str("type", n.cfg.Type).Msg("failed to send notification")
+		}
+	}
+}
+
+// loadFailed reads whether the last run failed from the outcome persisted in
+// path. A run which never happened didn't fail.
+func loadFailed(path string) (bool, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to read last outcome: %w", err)
+	}
+	switch outcome := strings.TrimSpace(string(data)); outcome {
+	case outcomeSuccess:
+		return false, nil
+	case outcomeFailure:
+		return true, nil
+	default:
+		return false, fmt.Errorf("unknown last outcome: %s", outcome)
+	}
+}
+
+// saveFailed persists the outcome of the last run in path.
+func saveFailed(path string, failed bool) error {
+	outcome := outcomeSuccess
+	if failed {
+		outcome = outcomeFailure
+	}
+	err := os.WriteFile(path, []byte(outcome+"\n"), 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write last outcome: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/guillembonet/backup/backup"
+	"github.com/guillembonet/backup/config"
+	"github.com/guillembonet/backup/notifications"
+)
+
+type recordingNotifier struct {
+	events []notifications.Event
+}
+
+func (n *recordingNotifier) Notify(ctx context.Context, event notifications.Event) error {
+	n.events = append(n.events, event)
+	return nil
+}
+
+func TestNotify(t *testing.T) {
+	runErr := errors.New("failed")
+	for _, test := range []struct {
+		name         string
+		cfg          config.Notification
+		failedBefore bool
+		err          error
+		want         string
+	}{
+		{name: "failure", cfg: config.Notification{OnFailure: true}, err: runErr, want: notifications.StatusFailure},
+		{name: "failure not wanted", cfg: config.Notification{OnSuccess: true, OnRecovery: true}, err: runErr},
+		{name: "failure after failure", cfg: config.Notification{OnFailure: true}, failedBefore: true, err: runErr, want: notifications.StatusFailure},
+		{name: "success", cfg: config.Notification{OnSuccess: true}, want: notifications.StatusSuccess},
+		{name: "success not wanted", cfg: config.Notification{OnFailure: true, OnRecovery: true}},
+		{name: "recovery", cfg: config.Notification{OnRecovery: true}, failedBefore: true, want: notifications.StatusRecovery},
+		{name: "recovery as success", cfg: config.Notification{OnSuccess: true}, failedBefore: true, want: notifications.StatusRecovery},
+		{name: "recovery not wanted", cfg: config.Notification{OnFailure: true}, failedBefore: true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			recorder := &recordingNotifier{}
+			d := &Daemon{notifiers: []notifier{{cfg: test.cfg, notifier: recorder}}}
+			j := &job{cfg: config.Job{Name: "db"}}
+
+			d.notify(j, test.failedBefore, &backup.Report{}, test.err)
+
+			if test.want == "" {
+				if len(recorder.events) != 0 {
+					t.Fatalf("got %d notifications, want none", len(recorder.events))
+				}
+				return
+			}
+			if len(recorder.events) != 1 {
+				t.Fatalf("got %d notifications, want 1", len(recorder.events))
+			}
+			event := recorder.events[0]
+			if event.Job != "db" || event.Status != test.want {
+				t.Errorf("got job %s with status %s, want db with %s", event.Job, event.Status, test.want)
+			}
+			if test.err != nil && event.Error != test.err.Error() {
+				t.Errorf("got error %q, want %q", event.Error, test.err.Error())
+			}
+		})
+	}
+}
+
+func TestOutcomePersisted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "last_outcome_db")
+	failed, err := loadFailed(path)
+	if err != nil || failed {
+		t.Fatalf("got %v, %v without a saved outcome, want false", failed, err)
+	}
+	for _, want := range []bool{true, false} {
+		err = saveFailed(path, want)
+		if err != nil {
+			t.Fatal(err)
+		}
+		failed, err = loadFailed(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if failed != want {
+			t.Errorf("got failed %v, want %v", failed, want)
+		}
+	}
+}
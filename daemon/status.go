@@ -0,0 +1,118 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/guillembonet/backup/backup"
+	"github.com/rs/zerolog/log"
+)
+
+// ErrUnknownJob is returned when triggering a job which is not configured.
+var ErrUnknownJob = errors.New("unknown job")
+
+// JobStatus is the state of a job and the outcome of its last run.
+type JobStatus struct {
+	Name         string     `json:"name"`
+	Running      bool       `json:"running"`
+	RunningSince *time.Time `json:"running_since,omitempty"`
+	NextRun      *time.Time `json:"next_run,omitempty"`
+	LastRun      *RunStatus `json:"last_run,omitempty"`
+}
+
+// RunStatus is the outcome of a run.
+type RunStatus struct {
+	Report *backup.Report `json:"report"`
+	Error  string         `json:"error,omitempty"`
+}
+
+// Status returns the state of every job.
+func (d *Daemon) Status() []JobStatus {
+	statuses := make([]JobStatus, len(d.jobs))
+	for i, j := range d.jobs {
+		j.statusMu.Lock()
+		statuses[i] = j.status
+		j.statusMu.Unlock()
+	}
+	return statuses
+}
+
+// Trigger starts a run of the job with the given name in the background, or of
+// every job if name is empty. It returns ErrSkipped if a job is already
+// running. Run waits for triggered runs before returning.
+func (d *Daemon) Trigger(ctx context.Context, name string) error {
+	found := false
+	for _, j := range d.jobs {
+		if name != "" && j.cfg.Name != name {
+			continue
+		}
+		found = true
+
+		j.statusMu.Lock()
+		running := j.status.Running
+		j.statusMu.Unlock()
+		if running {
+			return fmt.Errorf("job %s: %w", j.cfg.Name, ErrSkipped)
+		}
+	}
+	if !found {
+		return fmt.Errorf("%w: %s", ErrUnknownJob, name)
+	}
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	d.triggered.Add(1)
+	go func() {
+		defer d.triggered.Done()
+		var err error
+		if name == "" {
+			err = d.RunAll(ctx)
+		} else {
+			err = d.RunJob(ctx, name)
+		}
+		if err != nil {
+			log.Error().Err(err).Str("job", name).Msg("failed to run triggered backup")
+		}
+	}()
+	return nil
+}
+
+// Backups lists the backup files stored in the targets of every job, keyed by
+// job name.
+func (d *Daemon) Backups(ctx context.Context) map[string][]backup.RemoteBackups {
+	backups := map[string][]backup.RemoteBackups{}
+	for _, j := range d.jobs {
+		backups[j.cfg.Name] = j.backup.ListRemote(ctx)
+	}
+	return backups
+}
+
+func (j *job) setNextRun(next time.Time) {
+	j.statusMu.Lock()
+	defer j.statusMu.Unlock()
+	j.status.NextRun = &next
+}
+
+func (j *job) setRunning() {
+	j.statusMu.Lock()
+	defer j.statusMu.Unlock()
+	now := time.Now()
+	j.status.Running = true
+	j.status.RunningSince = &now
+}
+
+func (j *job) setFinished(report *backup.Report, err error) {
+	j.statusMu.Lock()
+	defer j.statusMu.Unlock()
+	j.status.Running = false
+	j.status.RunningSince = nil
+	j.status.LastRun = &RunStatus{
+		Report: report,
+	}
+	if err != nil {
+		j.status.LastRun.Error = err.Error()
+	}
+}
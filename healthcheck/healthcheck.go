@@ -0,0 +1,82 @@
+package healthcheck
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/guillembonet/backup/config"
+	"github.com/rs/zerolog/log"
+)
+
+// pingTimeout bounds the time spent on a single ping.
+const pingTimeout = 10 * time.Second
+
+// Pinger pings healthchecks.io style URLs when a run starts, succeeds or
+// fails. Unset URLs are not pinged and failing pings are only logged, so that
+// monitoring never makes a backup fail.
+type Pinger struct {
+	client *http.Client
+	cfg    config.Healthcheck
+}
+
+func New(cfg config.Healthcheck) *Pinger {
+	return &Pinger{
+		client: &http.Client{Timeout: pingTimeout},
+		cfg:    cfg,
+	}
+}
+
+func (p *Pinger) Start() {
+	p.ping(p.cfg.StartURL, "")
+}
+
+func (p *Pinger) Success() {
+	p.ping(p.cfg.SuccessURL, "")
+}
+
+// Fail pings the fail URL with the error text as body.
+func (p *Pinger) Fail(err error) {
+	p.ping(p.cfg.FailURL, err.Error())
+}
+
+// ping posts body to url. It doesn't take the context of the run because a
+// cancelled run must still be reported.
+func (p *Pinger) ping(url string, body string) {
+	if url == "" {
+		return
+	}
+
+	err := p.post(url, body)
+	if err != nil {
+		log.Warn().Err(err).Str("url", url).Msg("failed to ping healthcheck")
+		return
+	}
+	log.Debug().Str("url", url).Msg("pinged healthcheck")
+}
+
+func (p *Pinger) post(url string, body string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), pingTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, strings.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	return nil
+}
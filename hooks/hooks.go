@@ -0,0 +1,85 @@
+package hooks
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// DefaultTimeout is the time a hook command can run for if no timeout is
+// configured.
+const DefaultTimeout = 5 * time.Minute
+
+// maxOutputInError is the number of bytes of the output of a failed command
+// included in its error.
+const maxOutputInError = 512
+
+// Run runs every command through sh in order, stopping at the first failure.
+// Each command gets the given environment variables on top of the ones of the
+// process and is killed if it runs for longer than timeout.
+func Run(ctx context.Context, commands []string, timeout time.Duration, env map[string]string) error {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+
+	environ := os.Environ()
+	for key, value := range env {
+		environ = append(environ, key+"="+value)
+	}
+
+	for _, command := range commands {
+		err := run(ctx, command, timeout, environ)
+		if err != nil {
+			return fmt.Errorf("hook %q failed: %w", command, err)
+		}
+	}
+	return nil
+}
+
+func run(ctx context.Context, command string, timeout time.Duration, environ []string) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	cmd := Command(ctx, command)
+	cmd.Env = environ
+	start := time.Now()
+	output, err := cmd.CombinedOutput()
+	log.Debug().Str("command", command).
+		Dur("duration", time.Since(start)).
+		Str("output", string(output)).
+		Msg("ran hook")
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("timed out after %s", timeout)
+	}
+	if err != nil {
+		out := strings.TrimSpace(string(output))
+		if len(out) > maxOutputInError {
+			out = "..." + out[len(out)-maxOutputInError:]
+		}
+		if out == "" {
+			return err
+		}
+		return fmt.Errorf("%w: %s", err, out)
+	}
+	return nil
+}
+
+// Command prepares a command to be run through sh which is killed, together
+// with every process it started, when ctx is done.
+func Command(ctx context.Context, command string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	// kill the whole process group, otherwise children of the shell keep
+	// running and holding its output
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	cmd.WaitDelay = time.Second
+	return cmd
+}
@@ -0,0 +1,72 @@
+package lock
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// ErrLocked is returned when the lock is held by another running process.
+var ErrLocked = errors.New("locked by another process")
+
+// Lock is an exclusive lock between processes, an flock on a file held for as
+// long as the lock is. The kernel releases it when its owner exits, even if it
+// crashes, so a lock is never stale. The file holds the PID of the owner,
+// which is only informative.
+type Lock struct {
+	file *os.File
+}
+
+// Acquire takes the lock at path without blocking. It returns ErrLocked if it
+// is held by another running process.
+func Acquire(path string) (*Lock, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file: %w", err)
+	}
+	err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		data, _ := io.ReadAll(io.LimitReader(file, 32))
+		file.Close()
+		return nil, fmt.Errorf("%w (pid %s)", ErrLocked, strings.TrimSpace(string(data)))
+	}
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to lock file: %w", err)
+	}
+
+	err = writePID(file)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to write lock file: %w", err)
+	}
+	return &Lock{file: file}, nil
+}
+
+func writePID(file *os.File) error {
+	err := file.Truncate(0)
+	if err != nil {
+		return err
+	}
+	_, err = file.WriteAt([]byte(strconv.Itoa(os.Getpid())), 0)
+	return err
+}
+
+// Release frees the lock. The file is left in place, removing it would let
+// another process lock a new file while a third one still holds the old one.
+func (l *Lock) Release() error {
+	// an empty file tells that nobody holds the lock
+	err := l.file.Truncate(0)
+	closeErr := l.file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to release lock file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/guillembonet/backup/backup"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/rs/zerolog/log"
+)
+
+// Metrics exposes the outcome of the backup runs in Prometheus format.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	lastSuccess       *prometheus.GaugeVec
+	lastFailure       *prometheus.GaugeVec
+	runs              *prometheus.CounterVec
+	duration          *prometheus.GaugeVec
+	bytesRead         *prometheus.GaugeVec
+	archiveSize       *prometheus.GaugeVec
+	filesProcessed    *prometheus.GaugeVec
+	targetLastSuccess *prometheus.GaugeVec
+	targetLastFailure *prometheus.GaugeVec
+	uploadedBytes     *prometheus.CounterVec
+	retentionDeleted  *prometheus.CounterVec
+}
+
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		lastSuccess: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "backup_last_success_timestamp_seconds",
+			Help: "Time of the last successful run of the job.",
+		}, []string{"job"}),
+		lastFailure: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "backup_last_failure_timestamp_seconds",
+			Help: "Time of the last failed run of the job.",
+		}, []string{"job"}),
+		runs: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "backup_runs_total",
+			Help: "Number of runs of the job by result.",
+		}, []string{"job", "result"}),
+		duration: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "backup_last_duration_seconds",
+			Help: "Duration of the last run of the job.",
+		}, []string{"job"}),
+		bytesRead: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "backup_last_bytes_read",
+			Help: "Bytes read from the sources in the last run of the job.",
+		}, []string{"job"}),
+		archiveSize: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "backup_last_archive_size_bytes",
+			Help: "Size of the encrypted archive of the last run of the job.",
+		}, []string{"job"}),
+		filesProcessed: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "backup_last_files_processed",
+			Help: "Files backed up in the last run of the job.",
+		}, []string{"job"}),
+		targetLastSuccess: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "backup_target_last_success_timestamp_seconds",
+			Help: "Time of the last successful upload to the target.",
+		}, []string{"job", "target"}),
+		targetLastFailure: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "backup_target_last_failure_timestamp_seconds",
+			Help: "Time of the last failed upload to the target.",
+		}, []string{"job", "target"}),
+		uploadedBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "backup_target_uploaded_bytes_total",
+			Help: "Bytes uploaded to the target.",
+		}, []string{"job", "target"}),
+		retentionDeleted: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "backup_target_retention_deleted_total",
+			Help: "Expired backups deleted from the target.",
+		}, []string{"job", "target"}),
+	}
+	m.registry.MustRegister(
+		m.lastSuccess,
+		m.lastFailure,
+		m.runs,
+		m.duration,
+		m.bytesRead,
+		m.archiveSize,
+		m.filesProcessed,
+		m.targetLastSuccess,
+		m.targetLastFailure,
+		m.uploadedBytes,
+		m.retentionDeleted,
+	)
+	return m
+}
+
+// Observe records the outcome of a run of a job.
+func (m *Metrics) Observe(job string, report *backup.Report, err error) {
+	now := float64(time.Now().Unix())
+	if err != nil {
+		m.lastFailure.WithLabelValues(job).Set(now)
+		m.runs.WithLabelValues(job, "failure").Inc()
+	} else {
+		m.lastSuccess.WithLabelValues(job).Set(now)
+		m.runs.WithLabelValues(job, "success").Inc()
+	}
+
+	m.duration.WithLabelValues(job).Set(report.Duration().Seconds())
+	m.bytesRead.WithLabelValues(job).Set(float64(report.BytesRead))
+	m.archiveSize.WithLabelValues(job).Set(float64(report.ArchiveSize))
+	m.filesProcessed.WithLabelValues(job).Set(float64(report.FilesProcessed))
+
+	for _, target := range report.Targets {
+		if target.Error != "" {
+			m.targetLastFailure.WithLabelValues(job, target.Name).Set(now)
+		} else {
+			m.targetLastSuccess.WithLabelValues(job, target.Name).Set(now)
+		}
+		m.uploadedBytes.WithLabelValues(job, target.Name).Add(float64(target.UploadedBytes))
+		m.retentionDeleted.WithLabelValues(job, target.Name).Add(float64(target.Deleted))
+	}
+}
+
+// Serve exposes the metrics in /metrics on the given address until ctx is
+// cancelled.
+func (m *Metrics) Serve(ctx context.Context, listen string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}))
+	server := &http.Server{
+		Addr:    listen,
+		Handler: mux,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		err := server.Shutdown(shutdownCtx)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to shut down metrics server")
+		}
+	}()
+
+	log.Info().Str("listen", listen).Msg("serving metrics")
+	err := server.ListenAndServe()
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("failed to serve metrics: %w", err)
+	}
+	return nil
+}
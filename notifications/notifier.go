@@ -0,0 +1,57 @@
+package notifications
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/guillembonet/backup/backup"
+)
+
+// Statuses of a run reported in events.
+const (
+	StatusSuccess  = "success"
+	StatusFailure  = "failure"
+	StatusRecovery = "recovery"
+)
+
+type Notifier interface {
+	Notify(ctx context.Context, event Event) error
+}
+
+// Event describes the outcome of a run of a job.
+type Event struct {
+	Job             string         `json:"job"`
+	Status          string         `json:"status"`
+	DurationSeconds float64        `json:"duration_seconds"`
+	Report          *backup.Report `json:"report"`
+	Error           string         `json:"error,omitempty"`
+}
+
+// Title returns a short description of the event.
+func (e Event) Title() string {
+	return fmt.Sprintf("backup job %s: %s", e.Job, e.Status)
+}
+
+// Message returns a human readable summary of the run.
+func (e Event) Message() string {
+	var sb strings.Builder
+	sb.WriteString(e.Title() + "\n")
+	if e.Error != "" {
+		fmt.Fprintf(&sb, "error: %s\n", e.Error)
+	}
+	if e.Report != nil {
+		fmt.Fprintf(&sb, "duration: %s\n", e.Report.Duration().Round(time.Second))
+		fmt.Fprintf(&sb, "files: %d\n", e.Report.FilesProcessed)
+		fmt.Fprintf(&sb, "size: %d bytes\n", e.Report.ArchiveSize)
+		for _, target := range e.Report.Targets {
+			if target.Error != "" {
+				fmt.Fprintf(&sb, "target %s: failed: %s\n", target.Name, target.Error)
+			} else {
+				fmt.Fprintf(&sb, "target %s: uploaded %d bytes, deleted %d old backups\n", target.Name, target.UploadedBytes, target.Deleted)
+			}
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,166 @@
+package smtp
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/guillembonet/backup/notifications"
+)
+
+// Notifier sends events by email.
+type Notifier struct {
+	addr     string
+	host     string
+	username string
+	password string
+	from     string
+	to       []string
+}
+
+func NewNotifier(cfg map[string]string) (*Notifier, error) {
+	host, ok := cfg["host"]
+	if !ok {
+		return nil, fmt.Errorf("missing host")
+	}
+	port, ok := cfg["port"]
+	if !ok {
+		port = "25"
+	}
+	from, ok := cfg["from"]
+	if !ok {
+		return nil, fmt.Errorf("missing from")
+	}
+	to, ok := cfg["to"]
+	if !ok {
+		return nil, fmt.Errorf("missing to")
+	}
+
+	recipients := []string{}
+	for _, recipient := range strings.Split(to, ",") {
+		recipient = strings.TrimSpace(recipient)
+		if recipient != "" {
+			recipients = append(recipients, recipient)
+		}
+	}
+	if len(recipients) == 0 {
+		return nil, fmt.Errorf("no recipients")
+	}
+
+	return &Notifier{
+		addr:     net.JoinHostPort(host, port),
+		host:     host,
+		username: cfg["username"],
+		password: cfg["password"],
+		from:     from,
+		to:       recipients,
+	}, nil
+}
+
+func (n *Notifier) Notify(ctx context.Context, event notifications.Event) error {
+	var auth smtp.Auth
+	if n.username != "" {
+		auth = smtp.PlainAuth("", n.username, n.password, n.host)
+	}
+
+	msg := strings.Join([]string{
+		"From: " + n.from,
+		"To: " + strings.Join(n.to, ", "),
+		"Subject: " + event.Title(),
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=utf-8",
+		"",
+		strings.ReplaceAll(event.Message(), "\n", "\r\n"),
+	}, "\r\n")
+
+	err := n.send(ctx, auth, []byte(msg))
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	// the connection has the deadline of the context, and may expire first
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return context.DeadlineExceeded
+	}
+	if err != nil {
+		return fmt.Errorf("failed to send mail: %w", err)
+	}
+	return nil
+}
+
+// send sends a mail like smtp.SendMail, but over a connection which is closed
+// when the context is done.
+func (n *Notifier) send(ctx context.Context, auth smtp.Auth, msg []byte) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", n.addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		err = conn.SetDeadline(deadline)
+		if err != nil {
+			return err
+		}
+	}
+	// net/smtp doesn't support contexts, closing the connection interrupts it
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	client, err := smtp.NewClient(conn, n.host)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		err = client.StartTLS(&tls.Config{ServerName: n.host})
+		if err != nil {
+			return err
+		}
+	}
+	if auth != nil {
+		if ok, _ := client.Extension("AUTH"); !ok {
+			return fmt.Errorf("server doesn't support AUTH")
+		}
+		err = client.Auth(auth)
+		if err != nil {
+			return err
+		}
+	}
+	err = client.Mail(n.from)
+	if err != nil {
+		return err
+	}
+	for _, recipient := range n.to {
+		err = client.Rcpt(recipient)
+		if err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(msg)
+	if err != nil {
+		return err
+	}
+	err = w.Close()
+	if err != nil {
+		return err
+	}
+	return client.Quit()
+}
@@ -0,0 +1,203 @@
+package smtp
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/guillembonet/backup/notifications"
+)
+
+// mail is what the test server received.
+type mail struct {
+	from string
+	to   []string
+	data string
+}
+
+// startServer runs a minimal SMTP server accepting a single mail, rejecting
+// the recipients listed in reject.
+func startServer(t *testing.T, reject ...string) (string, string, <-chan mail) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	mails := make(chan mail, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		serve(conn, reject, mails)
+	}()
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	return host, port, mails
+}
+
+func serve(conn net.Conn, reject []string, mails chan<- mail) {
+	reader := bufio.NewReader(conn)
+	reply := func(line string) {
+		conn.Write([]byte(line + "\r\n"))
+	}
+
+	var m mail
+	reply("220 localhost ESMTP test")
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		command := strings.ToUpper(line)
+		switch {
+		case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+			reply("250 localhost")
+		case strings.HasPrefix(command, "MAIL FROM:"):
+			m.from = strings.Trim(line[len("MAIL FROM:"):], "<>")
+			reply("250 OK")
+		case strings.HasPrefix(command, "RCPT TO:"):
+			recipient := strings.Trim(line[len("RCPT TO:"):], "<>")
+			rejected := false
+			for _, r := range reject {
+				rejected = rejected || r == recipient
+			}
+			if rejected {
+				reply("550 no such user")
+				continue
+			}
+			m.to = append(m.to, recipient)
+			reply("250 OK")
+		case command == "DATA":
+			reply("354 end data with <CR><LF>.<CR><LF>")
+			var data strings.Builder
+			for {
+				line, err := reader.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				data.WriteString(line)
+			}
+			m.data = data.String()
+			reply("250 OK")
+			mails <- m
+		case command == "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 not implemented")
+		}
+	}
+}
+
+var testEvent = notifications.Event{
+	Job:    "db",
+	Status: notifications.StatusRecovery,
+}
+
+func TestNotify(t *testing.T) {
+	host, port, mails := startServer(t)
+	n, err := NewNotifier(map[string]string{
+		"host": host,
+		"port": port,
+		"from": "backup@example.com",
+		"to":   "a@example.com, b@example.com",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = n.Notify(context.Background(), testEvent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := <-mails
+	if m.from != "backup@example.com" {
+		t.Errorf("got sender %q", m.from)
+	}
+	if strings.Join(m.to, ",") != "a@example.com,b@example.com" {
+		t.Errorf("got recipients %v", m.to)
+	}
+	for _, header := range []string{
+		"From: backup@example.com\r\n",
+		"To: a@example.com, b@example.com\r\n",
+		"Subject: " + testEvent.Title() + "\r\n",
+	} {
+		if !strings.Contains(m.data, header) {
+			t.Errorf("missing header %q in %q", header, m.data)
+		}
+	}
+	if !strings.Contains(m.data, "\r\n\r\n"+strings.ReplaceAll(testEvent.Message(), "\n", "\r\n")) {
+		t.Errorf("missing message in %q", m.data)
+	}
+}
+
+func TestNotifyRejected(t *testing.T) {
+	host, port, _ := startServer(t, "a@example.com")
+	n, err := NewNotifier(map[string]string{
+		"host": host,
+		"port": port,
+		"from": "backup@example.com",
+		"to":   "a@example.com",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = n.Notify(context.Background(), testEvent)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestNotifyCancelled(t *testing.T) {
+	// a server which never answers
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	closed := make(chan error, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			closed <- err
+			return
+		}
+		defer conn.Close()
+		_, err = conn.Read(make([]byte, 1))
+		closed <- err
+	}()
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	n, err := NewNotifier(map[string]string{"host": host, "port": port, "from": "a@example.com", "to": "b@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err = n.Notify(ctx, testEvent)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("got %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	// the connection must not be left open
+	select {
+	case err := <-closed:
+		if err != io.EOF {
+			t.Errorf("got %v reading from the connection, want EOF", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("connection still open after Notify returned")
+	}
+}
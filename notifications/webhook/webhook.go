@@ -0,0 +1,93 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"text/template"
+	"time"
+
+	"github.com/guillembonet/backup/notifications"
+)
+
+// Notifier posts events to an HTTP endpoint. The body is the event as JSON
+// unless a template is configured, which makes it possible to talk to Slack,
+// Discord, ntfy and alike.
+type Notifier struct {
+	client      *http.Client
+	url         string
+	contentType string
+	template    *template.Template
+}
+
+func NewNotifier(cfg map[string]string) (*Notifier, error) {
+	url, ok := cfg["url"]
+	if !ok {
+		return nil, fmt.Errorf("missing url")
+	}
+
+	n := &Notifier{
+		client:      &http.Client{Timeout: 30 * time.Second},
+		url:         url,
+		contentType: "application/json",
+	}
+	if contentType, ok := cfg["content_type"]; ok {
+		n.contentType = contentType
+	}
+	if tmpl, ok := cfg["template"]; ok {
+		t, err := template.New("webhook").Funcs(template.FuncMap{
+			"json": toJSON,
+		}).Parse(tmpl)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse template: %w", err)
+		}
+		n.template = t
+	}
+	return n, nil
+}
+
+func (n *Notifier) Notify(ctx context.Context, event notifications.Event) error {
+	var body bytes.Buffer
+	if n.template != nil {
+		err := n.template.Execute(&body, event)
+		if err != nil {
+			return fmt.Errorf("failed to execute template: %w", err)
+		}
+	} else {
+		err := json.NewEncoder(&body).Encode(event)
+		if err != nil {
+			return fmt.Errorf("failed to encode event: %w", err)
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, &body)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", n.contentType)
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// toJSON encodes a value as JSON so that it can be safely embedded in a JSON
+// template, e.g. {"text": {{ .Message | json }}}.
+func toJSON(v any) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
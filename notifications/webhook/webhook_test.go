@@ -0,0 +1,116 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/guillembonet/backup/backup"
+	"github.com/guillembonet/backup/notifications"
+)
+
+type request struct {
+	contentType string
+	body        []byte
+}
+
+// startServer records the requests it receives and answers them with status.
+func startServer(t *testing.T, status int) (*httptest.Server, <-chan request) {
+	t.Helper()
+	requests := make(chan request, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests <- request{contentType: r.Header.Get("Content-Type"), body: body}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	return server, requests
+}
+
+var testEvent = notifications.Event{
+	Job:    "db",
+	Status: notifications.StatusFailure,
+	Report: &backup.Report{FilesProcessed: 3},
+	Error:  "failed to \"connect\"\nretrying",
+}
+
+func TestNotifyJSON(t *testing.T) {
+	server, requests := startServer(t, http.StatusOK)
+	n, err := NewNotifier(map[string]string{"url": server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = n.Notify(context.Background(), testEvent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := <-requests
+	if req.contentType != "application/json" {
+		t.Errorf("got content type %q", req.contentType)
+	}
+	var event notifications.Event
+	err = json.Unmarshal(req.body, &event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.Job != testEvent.Job || event.Status != testEvent.Status || event.Error != testEvent.Error || event.Report.FilesProcessed != 3 {
+		t.Errorf("got event %+v", event)
+	}
+}
+
+func TestNotifyTemplate(t *testing.T) {
+	server, requests := startServer(t, http.StatusNoContent)
+	n, err := NewNotifier(map[string]string{
+		"url":          server.URL,
+		"content_type": "application/vnd.test+json",
+		"template":     `{"title": {{ .Title | json }}, "text": {{ .Message | json }}}`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = n.Notify(context.Background(), testEvent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := <-requests
+	if req.contentType != "application/vnd.test+json" {
+		t.Errorf("got content type %q", req.contentType)
+	}
+	// quotes and new lines of the message must not break the JSON
+	var body struct {
+		Title string `json:"title"`
+		Text  string `json:"text"`
+	}
+	err = json.Unmarshal(req.body, &body)
+	if err != nil {
+		t.Fatalf("template produced invalid JSON %s: %v", req.body, err)
+	}
+	if body.Title != testEvent.Title() || body.Text != testEvent.Message() {
+		t.Errorf("got %+v", body)
+	}
+}
+
+func TestNotifyErrorStatus(t *testing.T) {
+	server, _ := startServer(t, http.StatusInternalServerError)
+	n, err := NewNotifier(map[string]string{"url": server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = n.Notify(context.Background(), testEvent)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestNewNotifierInvalidTemplate(t *testing.T) {
+	_, err := NewNotifier(map[string]string{"url": "http://localhost", "template": "{{ .Title"})
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+}
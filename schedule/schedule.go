@@ -0,0 +1,104 @@
+package schedule
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"strings"
+	"time"
+
+	// embed the timezone database, the docker image doesn't ship one
+	_ "time/tzdata"
+
+	"github.com/guillembonet/backup/config"
+	"github.com/robfig/cron/v3"
+)
+
+// Schedule decides when the next backup has to run, either following a cron
+// expression or a fixed interval after the previous run.
+type Schedule struct {
+	cron     cron.Schedule
+	interval time.Duration
+	jitter   time.Duration
+	location *time.Location
+}
+
+func New(cfg config.RunMode) (*Schedule, error) {
+	location := time.Local
+	if cfg.Timezone != "" {
+		var err error
+		location, err = time.LoadLocation(cfg.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load timezone: %w", err)
+		}
+	}
+	if cfg.Jitter < 0 {
+		return nil, fmt.Errorf("jitter can't be negative")
+	}
+
+	s := &Schedule{
+		interval: cfg.Interval,
+		jitter:   cfg.Jitter,
+		location: location,
+	}
+	switch {
+	case cfg.Schedule != "":
+		cronSchedule, err := cron.ParseStandard(cfg.Schedule)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse schedule: %w", err)
+		}
+		s.cron = cronSchedule
+	case cfg.Interval <= 0:
+		return nil, fmt.Errorf("either schedule or interval must be set")
+	}
+	return s, nil
+}
+
+// Next returns when the run following lastRun has to start. A zero lastRun
+// means there was no previous run, so the next one is the next time the cron
+// expression matches, or immediately for intervals.
+func (s *Schedule) Next(lastRun time.Time) time.Time {
+	if lastRun.IsZero() && s.cron == nil {
+		return time.Now()
+	}
+	if lastRun.IsZero() {
+		lastRun = time.Now()
+	}
+
+	var next time.Time
+	if s.cron != nil {
+		next = s.cron.Next(lastRun.In(s.location))
+	} else {
+		next = lastRun.Add(s.interval)
+	}
+	if s.jitter > 0 {
+		next = next.Add(time.Duration(rand.Int63n(int64(s.jitter))))
+	}
+	return next
+}
+
+// LoadLastRun reads the time of the last run persisted in path. A zero time is
+// returned if no run was persisted yet.
+func LoadLastRun(path string) (time.Time, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to read last run: %w", err)
+	}
+	lastRun, err := time.Parse(time.RFC3339, strings.TrimSpace(string(data)))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to parse last run: %w", err)
+	}
+	return lastRun, nil
+}
+
+// SaveLastRun persists the time of the last run in path.
+func SaveLastRun(path string, lastRun time.Time) error {
+	err := os.WriteFile(path, []byte(lastRun.Format(time.RFC3339)+"\n"), 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write last run: %w", err)
+	}
+	return nil
+}
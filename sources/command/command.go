@@ -0,0 +1,85 @@
+package command
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/guillembonet/backup/archive"
+	"github.com/guillembonet/backup/hooks"
+)
+
+// maxStderrInError is the number of bytes of the standard error of a failed
+// command included in its error.
+const maxStderrInError = 512
+
+// Source stores the standard output of a command, e.g. a database dump, as a
+// single file in the archive.
+type Source struct {
+	command  string
+	fileName string
+}
+
+func NewSource(command string, fileName string) (*Source, error) {
+	if command == "" {
+		return nil, fmt.Errorf("command is required")
+	}
+	if fileName == "" {
+		return nil, fmt.Errorf("file name is required")
+	}
+	if strings.ContainsAny(fileName, `/\`) || fileName == "." || fileName == ".." {
+		return nil, fmt.Errorf("invalid file name: %s", fileName)
+	}
+	return &Source{
+		command:  command,
+		fileName: fileName,
+	}, nil
+}
+
+// Backup streams the output of the command into the archive. The command
+// fails the backup if it exits with an error or writes anything to its
+// standard error.
+func (s *Source) Backup(ctx context.Context, archive *archive.Writer) error {
+	file, err := archive.CreateFile(s.fileName, 0644, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to add file: %w", err)
+	}
+
+	err = Run(ctx, hooks.Command(ctx, s.command), file)
+	if err != nil {
+		return fmt.Errorf("command %q failed: %w", s.command, err)
+	}
+	return nil
+}
+
+// Run runs cmd writing its standard output to stdout. It fails if the command
+// exits with an error or writes anything to its standard error, which is
+// included in the error.
+func Run(ctx context.Context, cmd *exec.Cmd, stdout io.Writer) error {
+	var stderr bytes.Buffer
+	cmd.Stdout = stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	out := strings.TrimSpace(stderr.String())
+	if len(out) > maxStderrInError {
+		out = "..." + out[len(out)-maxStderrInError:]
+	}
+	if err != nil {
+		if out == "" {
+			return err
+		}
+		return fmt.Errorf("%w: %s", err, out)
+	}
+	if out != "" {
+		return fmt.Errorf("wrote to stderr: %s", out)
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package command
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/guillembonet/backup/archive"
+	"github.com/guillembonet/backup/hooks"
+)
+
+func TestRun(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		script string
+		stdout string
+		// err is what the error has to contain, no error is expected if empty
+		err string
+	}{
+		{name: "success", script: "echo dump", stdout: "dump\n"},
+		{name: "exit code", script: "echo partial; exit 3", stdout: "partial\n", err: "exit status 3"},
+		{name: "exit code with stderr", script: "echo 'connection refused' >&2; exit 1", err: "exit status 1: connection refused"},
+		{name: "stderr", script: "echo dump; echo 'warning: something' >&2", stdout: "dump\n", err: "wrote to stderr: warning: something"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var stdout bytes.Buffer
+			err := Run(context.Background(), exec.Command("sh", "-c", test.script), &stdout)
+			if test.err == "" && err != nil {
+				t.Fatal(err)
+			}
+			if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+				t.Fatalf("got error %v, want one containing %q", err, test.err)
+			}
+			if stdout.String() != test.stdout {
+				t.Errorf("got output %q, want %q", stdout.String(), test.stdout)
+			}
+		})
+	}
+}
+
+func TestRunTruncatesStderr(t *testing.T) {
+	err := Run(context.Background(), exec.Command("sh", "-c", "printf 'x%.0s' $(seq 1000) >&2; echo end >&2"), io.Discard)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	// only the end of the output is kept, which usually explains the failure
+	if !strings.HasSuffix(err.Error(), "xend") || len(err.Error()) > maxStderrInError+len("wrote to stderr: ...") {
+		t.Errorf("got error %q of %d bytes", err, len(err.Error()))
+	}
+}
+
+func TestRunCancelled(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := Run(ctx, hooks.Command(ctx, "sleep 10"), io.Discard)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, want %v", err, context.DeadlineExceeded)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("returned after %s, the command wasn't killed", time.Since(start))
+	}
+}
+
+func TestBackup(t *testing.T) {
+	source, err := NewSource("echo dump; echo done", "dump.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := &bytes.Buffer{}
+	writer := archive.NewWriter(buf)
+	err = source.Backup(context.Background(), writer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = writer.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reader, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := reader.Open("dump.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "dump\ndone\n" {
+		t.Errorf("got %q", data)
+	}
+}
+
+func TestBackupFails(t *testing.T) {
+	for _, command := range []string{"exit 1", "echo oops >&2"} {
+		source, err := NewSource(command, "dump.sql")
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = source.Backup(context.Background(), archive.NewWriter(io.Discard))
+		if err == nil || !strings.Contains(err.Error(), command) {
+			t.Errorf("got error %v for %q, want one naming the command", err, command)
+		}
+	}
+}
+
+func TestNewSourceInvalidFileName(t *testing.T) {
+	for _, fileName := range []string{"", ".", "..", "dir/dump.sql", `dir\dump.sql`} {
+		_, err := NewSource("echo dump", fileName)
+		if err == nil {
+			t.Errorf("expected an error for %q", fileName)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package filter
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Filter selects the files of a source to back up with include and exclude
+// glob patterns. A pattern without a slash matches the name of a file or
+// directory at any depth, one with a slash matches its whole path relative to
+// the root of the source. Paths are always slash separated.
+//
+// Excluded files and directories are skipped, with everything below them. If
+// there are include patterns, only the files matching one of them, or inside a
+// directory matching one of them, are backed up.
+type Filter struct {
+	include []string
+	exclude []string
+}
+
+func New(include []string, exclude []string) (*Filter, error) {
+	for _, pattern := range append(append([]string{}, include...), exclude...) {
+		_, err := path.Match(pattern, "")
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+	}
+	return &Filter{
+		include: include,
+		exclude: exclude,
+	}, nil
+}
+
+// Match reports whether the file or directory at rel is backed up.
+func (f *Filter) Match(rel string) bool {
+	if matchAny(f.exclude, rel) {
+		return false
+	}
+	if len(f.include) == 0 {
+		return true
+	}
+	for p := rel; p != "." && p != "/"; p = path.Dir(p) {
+		if matchAny(f.include, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// Descend reports whether the directory at rel has to be walked, which is the
+// case unless it is excluded as files below it may be included.
+func (f *Filter) Descend(rel string) bool {
+	return !matchAny(f.exclude, rel)
+}
+
+func matchAny(patterns []string, rel string) bool {
+	for _, pattern := range patterns {
+		name := rel
+		if !strings.Contains(pattern, "/") {
+			name = path.Base(rel)
+		}
+		matched, _ := path.Match(strings.TrimPrefix(pattern, "/"), name)
+		if matched {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,32 @@
+package folder
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileID identifies a file across paths leading to it.
+type fileID struct {
+	dev uint64
+	ino uint64
+}
+
+func getFileID(info os.FileInfo) fileID {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fileID{}
+	}
+	return fileID{
+		dev: uint64(stat.Dev),
+		ino: uint64(stat.Ino),
+	}
+}
+
+func containsFileID(ids []fileID, id fileID) bool {
+	for _, other := range ids {
+		if other == id {
+			return true
+		}
+	}
+	return false
+}
@@ -1,29 +1,88 @@
 package folder
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
+
+	"github.com/guillembonet/backup/archive"
+	"github.com/guillembonet/backup/sources/filter"
+	"github.com/rs/zerolog/log"
+)
+
+// Behaviours when a file or directory of the source cannot be read.
+const (
+	OnErrorFail = "fail"
+	OnErrorWarn = "warn"
+	OnErrorSkip = "skip"
 )
 
 type Source struct {
-	source string
+	source           string
+	filter           *filter.Filter
+	onError          string
+	followSymlinks   bool
+	oneFileSystem    bool
+	skipSpecialFiles bool
+	includeCaches    bool
+}
+
+// Options configures which files of a folder are backed up and how.
+type Options struct {
+	Filter *filter.Filter
+	// OnError is what happens when a file or directory cannot be read,
+	// defaults to OnErrorFail. With OnErrorWarn and OnErrorSkip it is left
+	// out of the backup and recorded in the report, only logging it with
+	// OnErrorWarn.
+	OnError string
+	// FollowSymlinks backs up what symlinks point to instead of the links.
+	FollowSymlinks bool
+	// OneFileSystem doesn't descend into directories of other file systems
+	// than the one of the source, e.g. mount points.
+	OneFileSystem bool
+	// SkipSpecialFiles leaves out files which are neither regular files,
+	// directories nor symlinks, e.g. sockets and named pipes, instead of only
+	// storing their type and metadata.
+	SkipSpecialFiles bool
+	// IncludeCaches backs up the directories marked as caches with a
+	// CACHEDIR.TAG file, which are skipped otherwise.
+	IncludeCaches bool
 }
 
-func NewSource(source string) (*Source, error) {
+func NewSource(source string, opts Options) (*Source, error) {
+	switch opts.OnError {
+	case "":
+		opts.OnError = OnErrorFail
+	case OnErrorFail, OnErrorWarn, OnErrorSkip:
+	default:
+		return nil, fmt.Errorf("unknown on_error behaviour: %s", opts.OnError)
+	}
 	return &Source{
-		source: strings.TrimSuffix(source, "/"),
+		source:           strings.TrimSuffix(source, "/"),
+		filter:           opts.Filter,
+		onError:          opts.OnError,
+		followSymlinks:   opts.FollowSymlinks,
+		oneFileSystem:    opts.OneFileSystem,
+		skipSpecialFiles: opts.SkipSpecialFiles,
+		includeCaches:    opts.IncludeCaches,
 	}, nil
 }
 
-func (s *Source) Backup(destination string) error {
-	// get the base name of the source directory
-	sourceBase := filepath.Base(s.source)
-	// create the destination directory if it does not exist
-	destination = filepath.Join(destination, sourceBase)
-	err := recursiveCopy(s.source, destination)
+func (s *Source) Backup(ctx context.Context, archive *archive.Writer) error {
+	info, err := os.Stat(s.source)
+	if err != nil {
+		return fmt.Errorf("failed to stat source: %w", err)
+	}
+	err = archive.AddDir("", info)
+	if err != nil {
+		return fmt.Errorf("failed to add source directory: %w", err)
+	}
+
+	err = s.recursiveAdd(ctx, s.source, archive, "", []fileID{getFileID(info)})
 	if err != nil {
 		return fmt.Errorf("failed to copy files: %w", err)
 	}
@@ -31,44 +90,87 @@ func (s *Source) Backup(destination string) error {
 	return nil
 }
 
-func recursiveCopy(source string, destination string) error {
-	// create the destination directory if it does not exist
-	err := os.MkdirAll(destination, os.ModePerm)
-	if err != nil {
-		return err
-	}
-
+// recursiveAdd adds the content of the source directory matching the filter to
+// the archive under the given name. ancestors are the directories containing
+// it, used to detect loops when following symlinks.
+func (s *Source) recursiveAdd(ctx context.Context, source string, archive *archive.Writer, name string, ancestors []fileID) error {
 	// get a list of files in the source directory
-	files, err := filepath.Glob(filepath.Join(source, "*"))
+	entries, err := os.ReadDir(source)
 	if err != nil {
-		return err
+		return s.tolerate(archive, source, err)
 	}
 
-	// copy each file in the source directory to the destination directory
-	for _, file := range files {
-		if isDir(file) {
-			// recursively copy the directory
-			dirName := filepath.Base(file)
-			err = recursiveCopy(file, filepath.Join(destination, dirName))
+	// add each file in the source directory to the archive
+	for _, entry := range entries {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		file := filepath.Join(source, entry.Name())
+		fileName := path.Join(name, entry.Name())
+		info, err := os.Lstat(file)
+		if err == nil && info.Mode()&os.ModeSymlink != 0 {
+			if !s.followSymlinks {
+				if s.filter.Match(fileName) {
+					err = s.addSymlink(file, info, archive, fileName)
+					if err != nil {
+						return err
+					}
+				}
+				continue
+			}
+			info, err = os.Stat(file)
+		}
+		if err != nil {
+			err = s.tolerate(archive, file, err)
 			if err != nil {
 				return err
 			}
-		} else {
-			// copy the file
-			dest := filepath.Join(destination, filepath.Base(file))
-			src, err := os.Open(file)
+			continue
+		}
+
+		switch {
+		case info.IsDir():
+			if !s.filter.Descend(fileName) {
+				continue
+			}
+			if !s.includeCaches && isCacheDir(file) {
+				log.Debug().Str("path", file).Msg("skipped cache directory")
+				continue
+			}
+			id := getFileID(info)
+			if containsFileID(ancestors, id) {
+				log.Warn().Str("path", file).Msg("skipped symlink loop")
+				archive.Skip(file, "symlink loop")
+				continue
+			}
+			// recursively add the directory, only creating it if it is matched
+			// so that include patterns don't leave empty directories behind
+			if s.filter.Match(fileName) {
+				err = archive.AddDir(fileName, info)
+				if err != nil {
+					return err
+				}
+			}
+			// mount points are kept, as empty directories
+			if s.oneFileSystem && id.dev != ancestors[0].dev {
+				log.Debug().Str("path", file).Msg("not descending into another file system")
+				continue
+			}
+			err = s.recursiveAdd(ctx, file, archive, fileName, append(ancestors, id))
 			if err != nil {
 				return err
 			}
-			defer src.Close()
-
-			dst, err := os.Create(dest)
+		case !s.filter.Match(fileName):
+			// not a directory and not matched, nothing to do
+		case info.Mode().IsRegular():
+			err = s.addFile(ctx, file, info, archive, fileName)
 			if err != nil {
 				return err
 			}
-			defer dst.Close()
-
-			_, err = io.Copy(dst, src)
+		case s.skipSpecialFiles:
+			log.Debug().Str("path", file).Str("mode", info.Mode().String()).Msg("skipped special file")
+		default:
+			err = archive.AddSpecial(fileName, info)
 			if err != nil {
 				return err
 			}
@@ -77,10 +179,103 @@ func recursiveCopy(source string, destination string) error {
 	return nil
 }
 
-func isDir(path string) bool {
-	info, err := os.Stat(path)
+func (s *Source) addSymlink(file string, info os.FileInfo, archive *archive.Writer, name string) error {
+	target, err := os.Readlink(file)
+	if err != nil {
+		return s.tolerate(archive, file, err)
+	}
+	return archive.AddSymlink(name, info, target)
+}
+
+func (s *Source) addFile(ctx context.Context, file string, info os.FileInfo, archive *archive.Writer, name string) error {
+	src, err := os.Open(file)
+	if err != nil {
+		return s.tolerate(archive, file, err)
+	}
+	defer src.Close()
+
+	// a file which fails after it started to be written to the archive can't
+	// be left out anymore, so read errors always fail the backup
+	var n int64
+	expected := info.Size()
+	segments, sparse := dataSegments(src, info)
+	if sparse {
+		// only the data is stored, the holes are recreated on restore
+		expected = segmentsLength(segments)
+		n, err = archive.AddSparseFile(name, info, segments, &contextReaderAt{ctx: ctx, reader: src})
+	} else {
+		n, err = archive.AddFile(name, info, &contextReader{ctx: ctx, reader: src})
+	}
+	if err != nil {
+		return err
+	}
+
+	after, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	if n != expected || after.Size() != info.Size() || !after.ModTime().Equal(info.ModTime()) {
+		log.Warn().Str("path", file).Msg("file changed while being read")
+		archive.Flag(file, "changed while being read")
+	}
+	return nil
+}
+
+// tolerate handles an error reading a file or directory according to the
+// on_error behaviour, returning it if the backup has to fail.
+func (s *Source) tolerate(archive *archive.Writer, path string, err error) error {
+	switch s.onError {
+	case OnErrorWarn:
+		log.Warn().Err(err).Str("path", path).Msg("skipped unreadable file")
+	case OnErrorSkip:
+		log.Debug().Err(err).Str("path", path).Msg("skipped unreadable file")
+	default:
+		return err
+	}
+	archive.Skip(path, err.Error())
+	return nil
+}
+
+// contextReader stops reading as soon as its context is cancelled, so that
+// copying a large file can be interrupted.
+type contextReader struct {
+	ctx    context.Context
+	reader io.Reader
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if r.ctx.Err() != nil {
+		return 0, r.ctx.Err()
+	}
+	return r.reader.Read(p)
+}
+
+// contextReaderAt is a contextReader for the segments of sparse files.
+type contextReaderAt struct {
+	ctx    context.Context
+	reader io.ReaderAt
+}
+
+func (r *contextReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if r.ctx.Err() != nil {
+		return 0, r.ctx.Err()
+	}
+	return r.reader.ReadAt(p, off)
+}
+
+// cacheDirSignature is how a CACHEDIR.TAG file has to start, see
+// https://bford.info/cachedir/
+const cacheDirSignature = "Signature: 8a477f597d28d172789f06886806bc55"
+
+// isCacheDir reports whether dir is marked as a cache with a CACHEDIR.TAG file.
+func isCacheDir(dir string) bool {
+	file, err := os.Open(filepath.Join(dir, "CACHEDIR.TAG"))
 	if err != nil {
 		return false
 	}
-	return info.IsDir()
+	defer file.Close()
+
+	signature := make([]byte, len(cacheDirSignature))
+	_, err = io.ReadFull(file, signature)
+	return err == nil && string(signature) == cacheDirSignature
 }
@@ -0,0 +1,369 @@
+package folder
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/guillembonet/backup/archive"
+	"github.com/guillembonet/backup/sources/filter"
+)
+
+// entry is a file of the archive, symlinks hold their target as content.
+type entry struct {
+	mode    fs.FileMode
+	content string
+	sparse  bool
+}
+
+// backup backs up dir with the given options and returns the entries of the
+// archive by name, without the root directory, and the writer for its report.
+func backup(t *testing.T, dir string, opts Options) (map[string]entry, *archive.Writer, error) {
+	t.Helper()
+	if opts.Filter == nil {
+		opts.Filter = newFilter(t, nil)
+	}
+	source, err := NewSource(dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := &bytes.Buffer{}
+	writer := archive.NewWriter(buf)
+	err = source.Backup(context.Background(), writer)
+	if err != nil {
+		return nil, writer, err
+	}
+	err = writer.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reader, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := map[string]entry{}
+	for _, file := range reader.File {
+		if file.Name == "/" {
+			continue
+		}
+		r, err := archive.Open(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _, sparse := archive.SparseMap(file)
+		entries[file.Name] = entry{mode: file.Mode(), content: string(data), sparse: sparse}
+	}
+	return entries, writer, nil
+}
+
+func newFilter(t *testing.T, include []string) *filter.Filter {
+	t.Helper()
+	f, err := filter.New(include, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+// writeFiles creates files with the given content in dir, and their parent
+// directories.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		err := os.MkdirAll(filepath.Dir(path), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.WriteFile(path, []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// assertNames fails unless the archive holds exactly the given names.
+func assertNames(t *testing.T, entries map[string]entry, names ...string) {
+	t.Helper()
+	for _, name := range names {
+		if _, ok := entries[name]; !ok {
+			t.Errorf("%s missing from the archive", name)
+		}
+	}
+	if len(entries) != len(names) {
+		got := []string{}
+		for name := range entries {
+			got = append(got, name)
+		}
+		t.Errorf("got entries %v, want %v", got, names)
+	}
+}
+
+func TestSymlinksStoredAsLinks(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"sub/file": "content"})
+	err := os.Symlink("sub", filepath.Join(dir, "link"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries, _, err := backup(t, dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertNames(t, entries, "sub/", "sub/file", "link")
+	if link := entries["link"]; link.mode&fs.ModeSymlink == 0 || link.content != "sub" {
+		t.Errorf("got link with mode %s to %q, want a symlink to sub", link.mode, link.content)
+	}
+}
+
+func TestFollowSymlinks(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"sub/file": "content"})
+	err := os.Symlink("sub", filepath.Join(dir, "link"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries, _, err := backup(t, dir, Options{FollowSymlinks: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertNames(t, entries, "sub/", "sub/file", "link/", "link/file")
+	if entries["link/file"].content != "content" {
+		t.Errorf("got %q through the link", entries["link/file"].content)
+	}
+}
+
+func TestFollowSymlinksLoop(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		include []string
+		want    []string
+	}{
+		{name: "all", want: []string{"file", "sub/", "sub/file"}},
+		{name: "included", include: []string{"sub"}, want: []string{"sub/", "sub/file"}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{"file": "a", "sub/file": "b"})
+			err := os.Symlink("..", filepath.Join(dir, "sub", "up"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			entries, writer, err := backup(t, dir, Options{FollowSymlinks: true, Filter: newFilter(t, test.include)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertNames(t, entries, test.want...)
+			skipped := writer.Skipped()
+			if len(skipped) != 1 || skipped[0].Path != filepath.Join(dir, "sub", "up") {
+				t.Errorf("got skipped %v, want the loop", skipped)
+			}
+		})
+	}
+}
+
+func TestOneFileSystem(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"file": "a"})
+	// /proc is a file system of its own wherever it is mounted
+	var dirStat, procStat syscall.Stat_t
+	if syscall.Stat(dir, &dirStat) != nil || syscall.Stat("/proc", &procStat) != nil || dirStat.Dev == procStat.Dev {
+		t.Skip("/proc is not another file system")
+	}
+	err := os.Symlink("/proc", filepath.Join(dir, "proc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries, _, err := backup(t, dir, Options{FollowSymlinks: true, OneFileSystem: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the mount point is kept, without its content
+	assertNames(t, entries, "file", "proc/")
+}
+
+func TestSpecialFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"file": "a"})
+	err := syscall.Mkfifo(filepath.Join(dir, "fifo"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries, _, err := backup(t, dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertNames(t, entries, "file", "fifo")
+	if entries["fifo"].mode&fs.ModeNamedPipe == 0 {
+		t.Errorf("got fifo with mode %s, want a named pipe", entries["fifo"].mode)
+	}
+
+	entries, _, err = backup(t, dir, Options{SkipSpecialFiles: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertNames(t, entries, "file")
+}
+
+func TestOnError(t *testing.T) {
+	for _, onError := range []string{OnErrorFail, OnErrorWarn, OnErrorSkip} {
+		t.Run(onError, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{"file": "a"})
+			// a dangling link can't be read when following symlinks, even as root
+			err := os.Symlink("missing", filepath.Join(dir, "dangling"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			entries, writer, err := backup(t, dir, Options{OnError: onError, FollowSymlinks: true})
+			if onError == OnErrorFail {
+				if !errors.Is(err, fs.ErrNotExist) {
+					t.Fatalf("got %v, want the error of the dangling link", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertNames(t, entries, "file")
+			skipped := writer.Skipped()
+			if len(skipped) != 1 || skipped[0].Path != filepath.Join(dir, "dangling") {
+				t.Errorf("got skipped %v, want the dangling link", skipped)
+			}
+		})
+	}
+}
+
+func TestOnErrorUnreadable(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("root can read any file")
+	}
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"file": "a", "secret": "b", "private/file": "c"})
+	for _, name := range []string{"secret", "private"} {
+		err := os.Chmod(filepath.Join(dir, name), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Cleanup(func() { os.Chmod(filepath.Join(dir, "private"), 0755) })
+
+	_, _, err := backup(t, dir, Options{})
+	if !errors.Is(err, fs.ErrPermission) {
+		t.Fatalf("got %v, want a permission error", err)
+	}
+
+	entries, writer, err := backup(t, dir, Options{OnError: OnErrorSkip})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the directory is created before failing to be read
+	assertNames(t, entries, "file", "private/")
+	if len(writer.Skipped()) != 2 {
+		t.Errorf("got skipped %v, want secret and private", writer.Skipped())
+	}
+}
+
+func TestUnknownOnError(t *testing.T) {
+	_, err := NewSource(t.TempDir(), Options{OnError: "ignore"})
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestHiddenFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{".env": "a", ".ssh/id": "b", ".config/app/settings": "c", "visible": "d"})
+
+	entries, _, err := backup(t, dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertNames(t, entries, ".env", ".ssh/", ".ssh/id", ".config/", ".config/app/", ".config/app/settings", "visible")
+}
+
+func TestCacheDirs(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"file":                  "a",
+		"cache/CACHEDIR.TAG":    cacheDirSignature + "\n# a cache\n",
+		"cache/data":            "b",
+		"notcache/CACHEDIR.TAG": "not the signature",
+	})
+
+	entries, writer, err := backup(t, dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertNames(t, entries, "file", "notcache/", "notcache/CACHEDIR.TAG")
+	// cache dirs are left out on purpose, they aren't reported
+	if len(writer.Skipped()) != 0 {
+		t.Errorf("got skipped %v, want none", writer.Skipped())
+	}
+
+	entries, _, err = backup(t, dir, Options{IncludeCaches: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertNames(t, entries, "file", "cache/", "cache/CACHEDIR.TAG", "cache/data", "notcache/", "notcache/CACHEDIR.TAG")
+}
+
+func TestSparseFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"dense": "dense content"})
+	const size, offset = 8 << 20, 4 << 20
+	file, err := os.Create(filepath.Join(dir, "disk.img"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = file.WriteAt([]byte("data"), offset)
+	if err == nil {
+		err = file.Truncate(size)
+	}
+	file.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stat syscall.Stat_t
+	err = syscall.Stat(filepath.Join(dir, "disk.img"), &stat)
+	if err != nil || stat.Blocks*512 >= size {
+		t.Skip("the file system doesn't support holes")
+	}
+
+	entries, _, err := backup(t, dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	disk := entries["disk.img"]
+	if !disk.sparse {
+		t.Error("disk.img not stored as a sparse file")
+	}
+	want := make([]byte, size)
+	copy(want[offset:], "data")
+	if disk.content != string(want) {
+		t.Error("disk.img content differs")
+	}
+	if dense := entries["dense"]; dense.sparse || dense.content != "dense content" {
+		t.Errorf("got dense file %+v", dense)
+	}
+}
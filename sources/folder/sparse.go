@@ -0,0 +1,65 @@
+package folder
+
+import (
+	"errors"
+	"io"
+	"os"
+	"syscall"
+
+	"github.com/guillembonet/backup/archive"
+	"golang.org/x/sys/unix"
+)
+
+// dataSegments returns the ranges of a file holding data when it has holes,
+// found with SEEK_DATA and SEEK_HOLE. ok is false if the file has no holes or
+// the file system can't tell where they are, in which case it is read whole,
+// as well as if it has more data segments than an archive can record.
+func dataSegments(file *os.File, info os.FileInfo) (segments []archive.Segment, ok bool) {
+	stat, isStat := info.Sys().(*syscall.Stat_t)
+	// files using as many blocks as their size can't have holes
+	if !isStat || stat.Blocks*512 >= info.Size() {
+		return nil, false
+	}
+
+	// seeking moves the offset of the file, rewind it so that it can still be
+	// read whole
+	defer file.Seek(0, io.SeekStart)
+
+	fd := int(file.Fd())
+	offset := int64(0)
+	for offset < info.Size() {
+		start, err := unix.Seek(fd, offset, unix.SEEK_DATA)
+		if errors.Is(err, unix.ENXIO) {
+			// no more data, the rest of the file is a hole
+			break
+		}
+		if err != nil {
+			return nil, false
+		}
+		end, err := unix.Seek(fd, start, unix.SEEK_HOLE)
+		if err != nil {
+			return nil, false
+		}
+		if end > info.Size() {
+			end = info.Size()
+		}
+		if end <= start {
+			break
+		}
+		segments = append(segments, archive.Segment{Offset: start, Length: end - start})
+		if len(segments) > archive.MaxSegments {
+			return nil, false
+		}
+		offset = end
+	}
+	return segments, true
+}
+
+// segmentsLength is the total length of the data segments of a sparse file.
+func segmentsLength(segments []archive.Segment) int64 {
+	var length int64
+	for _, segment := range segments {
+		length += segment.Length
+	}
+	return length
+}
@@ -0,0 +1,159 @@
+package git
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/guillembonet/backup/archive"
+	"github.com/guillembonet/backup/sources/command"
+	"github.com/rs/zerolog/log"
+)
+
+// BundleExtension is the extension of the bundle of every repository in the
+// archive.
+const BundleExtension = ".bundle"
+
+// Source stores a bundle with every ref of git repositories, each one named
+// after the repository.
+type Source struct {
+	paths []string
+}
+
+// NewSource creates a source for the given paths, which are either
+// repositories or directories scanned for repositories.
+func NewSource(paths []string) (*Source, error) {
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("at least one path is required")
+	}
+	return &Source{
+		paths: paths,
+	}, nil
+}
+
+func (s *Source) Backup(ctx context.Context, archive *archive.Writer) error {
+	repos, err := s.findRepos()
+	if err != nil {
+		return err
+	}
+
+	for _, repo := range repos {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		err = bundle(ctx, repo.path, archive, repo.name+BundleExtension)
+		if err != nil {
+			return fmt.Errorf("failed to bundle %s: %w", repo.path, err)
+		}
+	}
+	return nil
+}
+
+type repo struct {
+	name string
+	path string
+}
+
+// findRepos returns the repositories to back up. Configured repositories are
+// named after their directory and scanned ones after their path relative to
+// the scanned directory.
+func (s *Source) findRepos() ([]repo, error) {
+	repos := []repo{}
+	paths := map[string]string{}
+	add := func(name string, path string) error {
+		if other, ok := paths[name]; ok {
+			return fmt.Errorf("repositories %s and %s have the same name", other, path)
+		}
+		paths[name] = path
+		repos = append(repos, repo{name: name, path: path})
+		return nil
+	}
+
+	for _, root := range s.paths {
+		if isRepo(root) {
+			err := add(filepath.Base(filepath.Clean(root)), root)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		err := filepath.WalkDir(root, func(dir string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !entry.IsDir() || !isRepo(dir) {
+				return nil
+			}
+			rel, err := filepath.Rel(root, dir)
+			if err != nil {
+				return err
+			}
+			err = add(filepath.ToSlash(rel), dir)
+			if err != nil {
+				return err
+			}
+			// repositories are not scanned for nested ones
+			return filepath.SkipDir
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan %s: %w", root, err)
+		}
+	}
+	return repos, nil
+}
+
+// isRepo reports whether dir is a git repository, either bare or with a
+// working tree.
+func isRepo(dir string) bool {
+	if exists(filepath.Join(dir, ".git")) {
+		return true
+	}
+	return exists(filepath.Join(dir, "HEAD")) && exists(filepath.Join(dir, "objects")) && exists(filepath.Join(dir, "refs"))
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func bundle(ctx context.Context, repo string, archive *archive.Writer, name string) error {
+	// git refuses to create empty bundles
+	var refs bytes.Buffer
+	err := command.Run(ctx, exec.CommandContext(ctx, "git", "-C", repo, "for-each-ref", "--count=1"), &refs)
+	if err != nil {
+		return fmt.Errorf("failed to list refs: %w", err)
+	}
+	if refs.Len() == 0 {
+		log.Warn().Str("repo", repo).Msg("skipped repository without refs")
+		return nil
+	}
+
+	file, err := archive.CreateFile(name, 0644, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to add file: %w", err)
+	}
+	cmd := exec.CommandContext(ctx, "git", "-C", repo, "bundle", "create", "--quiet", "-", "--all")
+	return command.Run(ctx, cmd, file)
+}
+
+// Restore clones the repository of a bundle created by the source into
+// destination as a mirror, with every ref of the original repository.
+func Restore(ctx context.Context, bundle string, destination string) error {
+	cmd := exec.CommandContext(ctx, "git", "clone", "--quiet", "--mirror", bundle, destination)
+	var output bytes.Buffer
+	return command.Run(ctx, cmd, &output)
+}
+
+// RepoName returns the name of the repository a bundle in the archive was
+// created from.
+func RepoName(name string) string {
+	return strings.TrimSuffix(path.Clean(name), BundleExtension)
+}
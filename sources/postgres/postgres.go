@@ -0,0 +1,136 @@
+package postgres
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/guillembonet/backup/archive"
+	"github.com/guillembonet/backup/sources/command"
+)
+
+// DumpExtension is the extension of the dump of every database in the archive.
+const DumpExtension = ".dump"
+
+// listDatabasesQuery lists the databases which can be dumped.
+const listDatabasesQuery = "SELECT datname FROM pg_database WHERE datallowconn AND NOT datistemplate ORDER BY datname"
+
+// Source stores a pg_dump custom-format dump of databases of a PostgreSQL
+// server, each one as a file named after the database.
+type Source struct {
+	connectionString string
+	databases        []string
+}
+
+// NewSource creates a source for the given databases of the server the
+// connection string connects to, or for all of them if none is given.
+func NewSource(connectionString string, databases []string) (*Source, error) {
+	if connectionString == "" {
+		return nil, fmt.Errorf("connection string is required")
+	}
+	_, err := WithDatabase(connectionString, "")
+	if err != nil {
+		return nil, err
+	}
+	return &Source{
+		connectionString: connectionString,
+		databases:        databases,
+	}, nil
+}
+
+func (s *Source) Backup(ctx context.Context, archive *archive.Writer) error {
+	databases := s.databases
+	if len(databases) == 0 {
+		var err error
+		databases, err = s.listDatabases(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to list databases: %w", err)
+		}
+	}
+
+	for _, database := range databases {
+		err := s.dump(ctx, database, archive)
+		if err != nil {
+			return fmt.Errorf("failed to dump database %s: %w", database, err)
+		}
+	}
+	return nil
+}
+
+func (s *Source) listDatabases(ctx context.Context) ([]string, error) {
+	var output bytes.Buffer
+	cmd := exec.CommandContext(ctx, "psql",
+		"--no-psqlrc", "--no-password", "--tuples-only", "--no-align",
+		"--dbname="+s.connectionString,
+		"--command="+listDatabasesQuery,
+	)
+	err := command.Run(ctx, cmd, &output)
+	if err != nil {
+		return nil, err
+	}
+	databases := []string{}
+	for _, line := range strings.Split(output.String(), "\n") {
+		if line != "" {
+			databases = append(databases, line)
+		}
+	}
+	return databases, nil
+}
+
+func (s *Source) dump(ctx context.Context, database string, archive *archive.Writer) error {
+	if database == "" || strings.ContainsAny(database, `/\`) {
+		return fmt.Errorf("unsupported database name")
+	}
+	connectionString, err := WithDatabase(s.connectionString, database)
+	if err != nil {
+		return err
+	}
+
+	file, err := archive.CreateFile(database+DumpExtension, 0644, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to add file: %w", err)
+	}
+	cmd := exec.CommandContext(ctx, "pg_dump", "--format=custom", "--no-password", "--dbname="+connectionString)
+	return command.Run(ctx, cmd, file)
+}
+
+// Restore restores a dump created by the source with pg_restore, creating its
+// database. The connection string must connect to another database of the
+// server, e.g. postgres. If clean is set the database is dropped first if it
+// already exists.
+func Restore(ctx context.Context, connectionString string, dump io.Reader, clean bool) error {
+	args := []string{"--create", "--exit-on-error", "--no-password", "--dbname=" + connectionString}
+	if clean {
+		args = append(args, "--clean", "--if-exists")
+	}
+	cmd := exec.CommandContext(ctx, "pg_restore", args...)
+	cmd.Stdin = dump
+	return command.Run(ctx, cmd, io.Discard)
+}
+
+// WithDatabase returns the connection string connecting to database instead of
+// the one it was configured with. It accepts both URIs and key=value
+// connection strings.
+func WithDatabase(connectionString string, database string) (string, error) {
+	if strings.HasPrefix(connectionString, "postgres://") || strings.HasPrefix(connectionString, "postgresql://") {
+		u, err := url.Parse(connectionString)
+		if err != nil {
+			// the error contains the connection string, which may have a password
+			return "", fmt.Errorf("invalid connection string")
+		}
+		u.Path = "/" + database
+		u.RawPath = ""
+		query := u.Query()
+		query.Del("dbname")
+		u.RawQuery = query.Encode()
+		return u.String(), nil
+	}
+	// later keywords override earlier ones
+	quoted := strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(database)
+	return fmt.Sprintf("%s dbname='%s'", connectionString, quoted), nil
+}
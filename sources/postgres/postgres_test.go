@@ -0,0 +1,165 @@
+package postgres
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/guillembonet/backup/archive"
+)
+
+// fakeTools puts shell scripts standing in for the PostgreSQL tools first in
+// PATH, returning the directory they write to.
+func fakeTools(t *testing.T, scripts map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, script := range scripts {
+		err := os.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\n"+script), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("FAKE_DIR", dir)
+	return dir
+}
+
+func backup(t *testing.T, source *Source) (map[string]string, error) {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	writer := archive.NewWriter(buf)
+	err := source.Backup(context.Background(), writer)
+	if err != nil {
+		return nil, err
+	}
+	err = writer.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reader, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{}
+	for _, file := range reader.File {
+		r, err := file.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[file.Name] = string(data)
+	}
+	return files, nil
+}
+
+func TestBackupAllDatabases(t *testing.T) {
+	fakeTools(t, map[string]string{
+		"psql":    `printf 'app\nusers\n'`,
+		"pg_dump": `echo "$@"`,
+	})
+	source, err := NewSource("postgres://backup@localhost/postgres", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := backup(t, source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"app.dump":   "--format=custom --no-password --dbname=postgres://backup@localhost/app\n",
+		"users.dump": "--format=custom --no-password --dbname=postgres://backup@localhost/users\n",
+	}
+	if len(files) != len(want) {
+		t.Errorf("got files %v, want %v", files, want)
+	}
+	for name, content := range want {
+		if files[name] != content {
+			t.Errorf("got %s with %q, want %q", name, files[name], content)
+		}
+	}
+}
+
+func TestBackupListedDatabases(t *testing.T) {
+	fakeTools(t, map[string]string{
+		"psql":    `echo "psql must not be run" >&2; exit 1`,
+		"pg_dump": `echo dump`,
+	})
+	source, err := NewSource("host=localhost user=backup", []string{"app"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := backup(t, source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files["app.dump"] != "dump\n" {
+		t.Errorf("got files %v", files)
+	}
+}
+
+func TestBackupDumpFails(t *testing.T) {
+	fakeTools(t, map[string]string{
+		"pg_dump": `echo 'pg_dump: error: connection refused' >&2; exit 1`,
+	})
+	source, err := NewSource("host=localhost", []string{"app"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = backup(t, source)
+	if err == nil || !strings.Contains(err.Error(), "connection refused") {
+		t.Fatalf("expected the error of pg_dump, got %v", err)
+	}
+}
+
+func TestRestore(t *testing.T) {
+	dir := fakeTools(t, map[string]string{
+		"pg_restore": `echo "$@" > "$FAKE_DIR/args"; cat > "$FAKE_DIR/stdin"`,
+	})
+
+	err := Restore(context.Background(), "host=localhost dbname=postgres", strings.NewReader("dump"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	args, _ := os.ReadFile(filepath.Join(dir, "args"))
+	if string(args) != "--create --exit-on-error --no-password --dbname=host=localhost dbname=postgres --clean --if-exists\n" {
+		t.Errorf("got arguments %q", args)
+	}
+	stdin, _ := os.ReadFile(filepath.Join(dir, "stdin"))
+	if string(stdin) != "dump" {
+		t.Errorf("got dump %q", stdin)
+	}
+}
+
+func TestWithDatabase(t *testing.T) {
+	for _, test := range []struct {
+		connectionString string
+		database         string
+		want             string
+	}{
+		{"postgres://u:p@host:5432/postgres?sslmode=disable", "app", "postgres://u:p@host:5432/app?sslmode=disable"},
+		{"postgresql://host/?dbname=postgres", "my db", "postgresql://host/my%20db"},
+		{"host=localhost dbname=postgres", "app", "host=localhost dbname=postgres dbname='app'"},
+		{"host=localhost", `it's\`, `host=localhost dbname='it\'s\\'`},
+	} {
+		got, err := WithDatabase(test.connectionString, test.database)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != test.want {
+			t.Errorf("WithDatabase(%q, %q) = %q, want %q", test.connectionString, test.database, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package s3
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/guillembonet/backup/archive"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/rs/zerolog/log"
+)
+
+// userMetadataPrefix is the prefix of the headers of user defined metadata.
+const userMetadataPrefix = "X-Amz-Meta-"
+
+// metadataHeaders are the headers of an object recorded besides its user
+// defined metadata.
+var metadataHeaders = []string{
+	"Content-Type",
+	"Content-Encoding",
+	"Content-Disposition",
+	"Content-Language",
+	"Cache-Control",
+	"Expires",
+}
+
+// Source stores the objects of an S3 bucket, or of a prefix of it, as files
+// named after their keys. Their metadata is recorded in the manifest.
+type Source struct {
+	client *minio.Client
+	bucket string
+	prefix string
+}
+
+// NewSource creates a source for a bucket configured with the keys endpoint
+// (host with an optional port), bucket, prefix, access_key, secret_key,
+// region and use_ssl, which defaults to true.
+func NewSource(config map[string]string) (*Source, error) {
+	endpoint, ok := config["endpoint"]
+	if !ok {
+		return nil, fmt.Errorf("endpoint is required")
+	}
+	bucket, ok := config["bucket"]
+	if !ok {
+		return nil, fmt.Errorf("bucket is required")
+	}
+	useSSL := true
+	if value, ok := config["use_ssl"]; ok {
+		var err error
+		useSSL, err = strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid use_ssl: %w", err)
+		}
+	}
+
+	client, err := minio.New(endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(config["access_key"], config["secret_key"], ""),
+		Secure: useSSL,
+		Region: config["region"],
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create client: %w", err)
+	}
+	return &Source{
+		client: client,
+		bucket: bucket,
+		prefix: config["prefix"],
+	}, nil
+}
+
+func (s *Source) Backup(ctx context.Context, archive *archive.Writer) error {
+	objects := s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{
+		Prefix:    s.prefix,
+		Recursive: true,
+	})
+	for object := range objects {
+		if object.Err != nil {
+			return fmt.Errorf("failed to list objects: %w", object.Err)
+		}
+		if strings.HasSuffix(object.Key, "/") {
+			// directory markers have no content
+			continue
+		}
+		if path.Clean("/"+object.Key) != "/"+object.Key {
+			log.Warn().Str("key", object.Key).Msg("skipped object whose key is not a clean path")
+			archive.Skip(object.Key, "key is not a clean path")
+			continue
+		}
+
+		err := s.addObject(ctx, object.Key, archive)
+		if err != nil {
+			return fmt.Errorf("failed to copy object %s: %w", object.Key, err)
+		}
+	}
+	return ctx.Err()
+}
+
+func (s *Source) addObject(ctx context.Context, key string, archive *archive.Writer) error {
+	object, err := s.client.GetObject(ctx, s.bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return err
+	}
+	defer object.Close()
+
+	info, err := object.Stat()
+	if err != nil {
+		return err
+	}
+	_, err = archive.AddFile(key, objectInfo{info}, object)
+	if err != nil {
+		return err
+	}
+
+	metadata := map[string]string{
+		"ETag": info.ETag,
+	}
+	for _, header := range metadataHeaders {
+		if value := info.Metadata.Get(header); value != "" {
+			metadata[header] = value
+		}
+	}
+	for header := range info.Metadata {
+		if strings.HasPrefix(header, userMetadataPrefix) {
+			metadata[header] = info.Metadata.Get(header)
+		}
+	}
+	archive.SetMetadata(key, metadata)
+	return nil
+}
+
+// objectInfo describes an object as a regular file.
+type objectInfo struct {
+	info minio.ObjectInfo
+}
+
+func (i objectInfo) Name() string       { return path.Base(i.info.Key) }
+func (i objectInfo) Size() int64        { return i.info.Size }
+func (i objectInfo) Mode() fs.FileMode  { return 0644 }
+func (i objectInfo) ModTime() time.Time { return i.info.LastModified }
+func (i objectInfo) IsDir() bool        { return false }
+func (i objectInfo) Sys() any           { return nil }
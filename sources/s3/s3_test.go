@@ -0,0 +1,165 @@
+package s3
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/guillembonet/backup/archive"
+)
+
+type object struct {
+	content string
+	headers map[string]string
+}
+
+// fakeS3 serves just enough of the S3 API for the source: listing a bucket and
+// getting objects.
+type fakeS3 struct {
+	bucket  string
+	objects map[string]object
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket != f.bucket {
+		http.Error(w, "no such bucket", http.StatusNotFound)
+		return
+	}
+	if key == "" {
+		f.list(w, r.URL.Query().Get("prefix"))
+		return
+	}
+
+	obj, ok := f.objects[key]
+	if !ok {
+		http.Error(w, "no such key", http.StatusNotFound)
+		return
+	}
+	for header, value := range obj.headers {
+		w.Header().Set(header, value)
+	}
+	w.Header().Set("ETag", etag(obj.content))
+	w.Header().Set("Last-Modified", "Tue, 02 Jan 2024 03:04:05 GMT")
+	w.Header().Set("Content-Length", fmt.Sprint(len(obj.content)))
+	if r.Method == http.MethodGet {
+		io.WriteString(w, obj.content)
+	}
+}
+
+func (f *fakeS3) list(w http.ResponseWriter, prefix string) {
+	keys := []string{}
+	for key := range f.objects {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var contents strings.Builder
+	for _, key := range keys {
+		fmt.Fprintf(&contents, "<Contents><Key>%s</Key><Size>%d</Size><ETag>%s</ETag><LastModified>2024-01-02T03:04:05.000Z</LastModified></Contents>",
+			key, len(f.objects[key].content), etag(f.objects[key].content))
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?><ListBucketResult><Name>%s</Name><Prefix>%s</Prefix><KeyCount>%d</KeyCount><MaxKeys>1000</MaxKeys><IsTruncated>false</IsTruncated>%s</ListBucketResult>`,
+		f.bucket, prefix, len(keys), contents.String())
+}
+
+func etag(content string) string {
+	sum := md5.Sum([]byte(content))
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+func TestBackup(t *testing.T) {
+	server := httptest.NewServer(&fakeS3{
+		bucket: "backups",
+		objects: map[string]object{
+			"data/a.txt": {content: "hello", headers: map[string]string{
+				"Content-Type":     "text/plain",
+				"X-Amz-Meta-Owner": "alice",
+			}},
+			"data/sub/b.bin": {content: "\x00\x01", headers: map[string]string{"Content-Type": "application/octet-stream"}},
+			"data/dir/":      {},
+			"data//unclean":  {content: "x"},
+			"other/c":        {content: "c"},
+		},
+	})
+	defer server.Close()
+	endpoint, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	source, err := NewSource(map[string]string{
+		"endpoint":   endpoint.Host,
+		"bucket":     "backups",
+		"prefix":     "data/",
+		"access_key": "access",
+		"secret_key": "secret",
+		"region":     "us-east-1",
+		"use_ssl":    "false",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := &bytes.Buffer{}
+	writer := archive.NewWriter(buf)
+	err = source.Backup(context.Background(), writer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = writer.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reader, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{}
+	for _, file := range reader.File {
+		r, err := file.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[file.Name] = string(data)
+	}
+	if len(files) != 2 || files["data/a.txt"] != "hello" || files["data/sub/b.bin"] != "\x00\x01" {
+		t.Errorf("got files %q", files)
+	}
+
+	metadata := writer.Metadata()["data/a.txt"]
+	want := map[string]string{
+		"ETag":             etag("hello"),
+		"Content-Type":     "text/plain",
+		"X-Amz-Meta-Owner": "alice",
+	}
+	for key, value := range want {
+		if strings.Trim(metadata[key], `"`) != strings.Trim(value, `"`) {
+			t.Errorf("got metadata %s=%q, want %q", key, metadata[key], value)
+		}
+	}
+
+	skipped := writer.Skipped()
+	if len(skipped) != 1 || skipped[0].Path != "data//unclean" {
+		t.Errorf("got skipped %v, want only data//unclean", skipped)
+	}
+}
@@ -0,0 +1,250 @@
+package sftp
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/guillembonet/backup/archive"
+	"github.com/guillembonet/backup/sources/filter"
+	"github.com/pkg/sftp"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+const (
+	defaultPort = "22"
+	dialTimeout = 30 * time.Second
+)
+
+// Source stores the content of a directory of a remote server over SFTP.
+// Only regular files and directories are backed up.
+type Source struct {
+	address      string
+	clientConfig *ssh.ClientConfig
+	path         string
+	filter       *filter.Filter
+}
+
+// NewSource creates a source for the remote directory at path. The server is
+// configured with the keys address (host with an optional port), username,
+// password and/or private_key_file (with private_key_passphrase if it is
+// encrypted), and host_key (in authorized_keys format) or known_hosts, which
+// defaults to ~/.ssh/known_hosts, to verify the server.
+func NewSource(path string, config map[string]string, filter *filter.Filter) (*Source, error) {
+	if path == "" {
+		return nil, fmt.Errorf("path is required")
+	}
+	address, ok := config["address"]
+	if !ok {
+		return nil, fmt.Errorf("address is required")
+	}
+	_, _, err := net.SplitHostPort(address)
+	if err != nil {
+		address = net.JoinHostPort(address, defaultPort)
+	}
+	username, ok := config["username"]
+	if !ok {
+		return nil, fmt.Errorf("username is required")
+	}
+
+	auth, err := authMethods(config)
+	if err != nil {
+		return nil, err
+	}
+	hostKeyCallback, err := hostKeyCallback(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Source{
+		address: address,
+		clientConfig: &ssh.ClientConfig{
+			User:            username,
+			Auth:            auth,
+			HostKeyCallback: hostKeyCallback,
+			Timeout:         dialTimeout,
+		},
+		path:   path,
+		filter: filter,
+	}, nil
+}
+
+func authMethods(config map[string]string) ([]ssh.AuthMethod, error) {
+	auth := []ssh.AuthMethod{}
+	if keyFile, ok := config["private_key_file"]; ok {
+		key, err := os.ReadFile(keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read private key: %w", err)
+		}
+		var signer ssh.Signer
+		if passphrase, ok := config["private_key_passphrase"]; ok {
+			signer, err = ssh.ParsePrivateKeyWithPassphrase(key, []byte(passphrase))
+		} else {
+			signer, err = ssh.ParsePrivateKey(key)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse private key: %w", err)
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if password, ok := config["password"]; ok {
+		auth = append(auth, ssh.Password(password))
+	}
+	if len(auth) == 0 {
+		return nil, fmt.Errorf("password or private_key_file is required")
+	}
+	return auth, nil
+}
+
+func hostKeyCallback(config map[string]string) (ssh.HostKeyCallback, error) {
+	if hostKey, ok := config["host_key"]; ok {
+		key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(hostKey))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse host key: %w", err)
+		}
+		return ssh.FixedHostKey(key), nil
+	}
+
+	knownHosts, ok := config["known_hosts"]
+	if !ok {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, fmt.Errorf("failed to find known hosts: %w", err)
+		}
+		knownHosts = filepath.Join(home, ".ssh", "known_hosts")
+	}
+	callback, err := knownhosts.New(knownHosts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read known hosts: %w", err)
+	}
+	return callback, nil
+}
+
+func (s *Source) Backup(ctx context.Context, archive *archive.Writer) error {
+	client, disconnect, err := s.connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer disconnect()
+
+	info, err := client.Stat(s.path)
+	if err != nil {
+		return fmt.Errorf("failed to stat source: %w", err)
+	}
+	err = archive.AddDir("", info)
+	if err != nil {
+		return fmt.Errorf("failed to add source directory: %w", err)
+	}
+
+	err = s.recursiveAdd(ctx, client, s.path, archive, "")
+	if ctx.Err() != nil {
+		// the connection is closed when the context is cancelled, which is
+		// what fails the transfer
+		return ctx.Err()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to copy files: %w", err)
+	}
+	return nil
+}
+
+// connect opens an SFTP session which is closed as soon as ctx is cancelled.
+// The returned function closes the session.
+func (s *Source) connect(ctx context.Context) (*sftp.Client, func(), error) {
+	dialer := net.Dialer{Timeout: dialTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", s.address)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to connect: %w", err)
+	}
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, s.address, s.clientConfig)
+	if err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("failed to open ssh connection: %w", err)
+	}
+	sshClient := ssh.NewClient(sshConn, chans, reqs)
+	client, err := sftp.NewClient(sshClient)
+	if err != nil {
+		sshClient.Close()
+		return nil, nil, fmt.Errorf("failed to start sftp session: %w", err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			sshClient.Close()
+		case <-done:
+		}
+	}()
+	return client, func() {
+		close(done)
+		client.Close()
+		sshClient.Close()
+	}, nil
+}
+
+// recursiveAdd adds the content of the remote directory matching the filter
+// to the archive under the given name.
+func (s *Source) recursiveAdd(ctx context.Context, client *sftp.Client, dir string, archive *archive.Writer, name string) error {
+	files, err := client.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Name() < files[j].Name()
+	})
+
+	for _, info := range files {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		file := path.Join(dir, info.Name())
+		fileName := path.Join(name, info.Name())
+
+		switch {
+		case info.IsDir():
+			if !s.filter.Descend(fileName) {
+				continue
+			}
+			if s.filter.Match(fileName) {
+				err = archive.AddDir(fileName, info)
+				if err != nil {
+					return err
+				}
+			}
+			err = s.recursiveAdd(ctx, client, file, archive, fileName)
+			if err != nil {
+				return err
+			}
+		case !s.filter.Match(fileName):
+			// not a directory and not matched, nothing to do
+		case !info.Mode().IsRegular():
+			log.Warn().Str("path", file).Str("mode", info.Mode().String()).Msg("skipped remote file which is not regular")
+			archive.Skip(file, "not a regular file")
+		default:
+			err = addFile(client, file, info, archive, fileName)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func addFile(client *sftp.Client, file string, info os.FileInfo, archive *archive.Writer, name string) error {
+	src, err := client.Open(file)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	_, err = archive.AddFile(name, info, src)
+	return err
+}
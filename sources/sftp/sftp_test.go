@@ -0,0 +1,215 @@
+package sftp
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/guillembonet/backup/archive"
+	"github.com/guillembonet/backup/sources/filter"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	testUsername = "backup"
+	testPassword = "secret"
+)
+
+// startServer serves the local file system over SFTP on a random port until
+// the test ends, returning its address and host key.
+func startServer(t *testing.T) (string, ssh.PublicKey) {
+	t.Helper()
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if conn.User() == testUsername && string(password) == testPassword {
+				return nil, nil
+			}
+			return nil, os.ErrPermission
+		},
+	}
+	config.AddHostKey(signer)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveConn(conn, config)
+		}
+	}()
+	return listener.Addr().String(), signer.PublicKey()
+}
+
+func serveConn(conn net.Conn, config *ssh.ServerConfig) {
+	defer conn.Close()
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			for req := range requests {
+				ok := req.Type == "subsystem" && string(req.Payload[4:]) == "sftp"
+				req.Reply(ok, nil)
+				if !ok {
+					continue
+				}
+				server, err := sftp.NewServer(channel)
+				if err != nil {
+					channel.Close()
+					return
+				}
+				server.Serve()
+				channel.Close()
+			}
+		}()
+	}
+}
+
+func newTestSource(t *testing.T, address string, hostKey ssh.PublicKey, dir string, password string, exclude []string) *Source {
+	t.Helper()
+	f, err := filter.New(nil, exclude)
+	if err != nil {
+		t.Fatal(err)
+	}
+	source, err := NewSource(dir, map[string]string{
+		"address":  address,
+		"username": testUsername,
+		"password": password,
+		"host_key": string(ssh.MarshalAuthorizedKey(hostKey)),
+	}, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return source
+}
+
+func writeFile(t *testing.T, path string, content string) {
+	t.Helper()
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(path, []byte(content), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBackup(t *testing.T) {
+	address, hostKey := startServer(t)
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "a.txt"), "a")
+	writeFile(t, filepath.Join(dir, "sub", "b.txt"), "bb")
+	writeFile(t, filepath.Join(dir, "c.tmp"), "excluded")
+	for _, name := range []string{"pipe", "pipe.tmp"} {
+		err := syscall.Mkfifo(filepath.Join(dir, name), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	source := newTestSource(t, address, hostKey, dir, testPassword, []string{"*.tmp"})
+	buf := &bytes.Buffer{}
+	writer := archive.NewWriter(buf)
+	err := source.Backup(context.Background(), writer.Sub("src"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = writer.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reader, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents := map[string]string{}
+	names := []string{}
+	for _, file := range reader.File {
+		names = append(names, file.Name)
+		if file.Mode().IsDir() {
+			continue
+		}
+		r, err := file.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		contents[file.Name] = string(data)
+	}
+	sort.Strings(names)
+	want := []string{"src/", "src/a.txt", "src/sub/", "src/sub/b.txt"}
+	if strings.Join(names, ",") != strings.Join(want, ",") {
+		t.Errorf("got entries %v, want %v", names, want)
+	}
+	if contents["src/a.txt"] != "a" || contents["src/sub/b.txt"] != "bb" {
+		t.Errorf("unexpected contents %v", contents)
+	}
+
+	// the excluded pipe is left out silently, the other one is reported
+	skipped := writer.Skipped()
+	if len(skipped) != 1 || skipped[0].Path != filepath.Join(dir, "pipe") {
+		t.Errorf("got skipped %v, want only the pipe", skipped)
+	}
+}
+
+func TestBackupWrongPassword(t *testing.T) {
+	address, hostKey := startServer(t)
+	source := newTestSource(t, address, hostKey, t.TempDir(), "wrong", nil)
+
+	err := source.Backup(context.Background(), archive.NewWriter(io.Discard))
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestBackupWrongHostKey(t *testing.T) {
+	address, _ := startServer(t)
+	_, otherHostKey := startServer(t)
+	source := newTestSource(t, address, otherHostKey, t.TempDir(), testPassword, nil)
+
+	err := source.Backup(context.Background(), archive.NewWriter(io.Discard))
+	if err == nil || !strings.Contains(err.Error(), "host key") {
+		t.Fatalf("expected a host key error, got %v", err)
+	}
+}
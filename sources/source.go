@@ -1,9 +1,15 @@
 package sources
 
-import "fmt"
+import (
+	"context"
+	"fmt"
+
+	"github.com/guillembonet/backup/archive"
+)
 
 type Source interface {
-	Backup(destination string) error
+	// Backup adds the content of the source to the archive.
+	Backup(ctx context.Context, archive *archive.Writer) error
 }
 
 var (
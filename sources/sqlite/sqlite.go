@@ -0,0 +1,122 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/guillembonet/backup/archive"
+	// registers the pure Go sqlite driver, which doesn't need cgo
+	_ "modernc.org/sqlite"
+)
+
+// busyTimeout is how long to wait for a writer to release the database before
+// failing the snapshot.
+const busyTimeout = 30 * time.Second
+
+// Source stores a consistent snapshot of SQLite databases which may be in use,
+// each one as a file named after the database.
+type Source struct {
+	paths      []string
+	newWorkDir func() (string, func() error, error)
+}
+
+// NewSource creates a source for the databases at paths. Snapshots are written
+// to directories created by newWorkDir before being added to the archive, which
+// are removed with the function it returns.
+func NewSource(paths []string, newWorkDir func() (string, func() error, error)) (*Source, error) {
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("at least one database path is required")
+	}
+	names := map[string]bool{}
+	for i, path := range paths {
+		path, err := filepath.Abs(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve database path: %w", err)
+		}
+		name := filepath.Base(path)
+		if names[name] {
+			return nil, fmt.Errorf("more than one database named %s", name)
+		}
+		names[name] = true
+		paths[i] = path
+	}
+	return &Source{
+		paths:      paths,
+		newWorkDir: newWorkDir,
+	}, nil
+}
+
+func (s *Source) Backup(ctx context.Context, archive *archive.Writer) error {
+	workDir, removeWorkDir, err := s.newWorkDir()
+	if err != nil {
+		return fmt.Errorf("failed to create work dir: %w", err)
+	}
+	defer removeWorkDir()
+
+	for _, path := range s.paths {
+		name := filepath.Base(path)
+		snapshotPath := filepath.Join(workDir, name)
+		err := snapshot(ctx, path, snapshotPath)
+		if err != nil {
+			return fmt.Errorf("failed to snapshot %s: %w", path, err)
+		}
+		err = addFile(snapshotPath, archive, name)
+		if err != nil {
+			return fmt.Errorf("failed to add %s: %w", path, err)
+		}
+		err = os.Remove(snapshotPath)
+		if err != nil {
+			return fmt.Errorf("failed to remove snapshot: %w", err)
+		}
+	}
+	return nil
+}
+
+// snapshot copies the database at path to destination with VACUUM INTO, which
+// reads it in a single transaction so that the copy is consistent even if the
+// database is written to meanwhile.
+func snapshot(ctx context.Context, path string, destination string) error {
+	// the driver reports missing databases with an unhelpful error
+	_, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	// open the database read-only so that a missing one isn't created
+	dsn := &url.URL{
+		Scheme:   "file",
+		Path:     path,
+		RawQuery: fmt.Sprintf("mode=ro&_pragma=busy_timeout(%d)", busyTimeout.Milliseconds()),
+	}
+	db, err := sql.Open("sqlite", dsn.String())
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	_, err = db.ExecContext(ctx, "VACUUM INTO ?", destination)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+func addFile(path string, archive *archive.Writer, name string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	_, err = archive.AddFile(name, info, file)
+	return err
+}
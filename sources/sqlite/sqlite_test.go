@@ -0,0 +1,174 @@
+package sqlite
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"database/sql"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/guillembonet/backup/archive"
+)
+
+// batchSize is how many rows the writer inserts per transaction, a consistent
+// snapshot has a multiple of it.
+const batchSize = 10
+
+func openDB(t *testing.T, path string) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=busy_timeout(30000)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// insertBatch inserts batchSize rows in a single transaction.
+func insertBatch(db *sql.DB) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	for i := 0; i < batchSize; i++ {
+		_, err = tx.Exec("INSERT INTO items (name) VALUES (?)", "item")
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func TestBackupWhileWriting(t *testing.T) {
+	for _, journalMode := range []string{"delete", "wal"} {
+		t.Run(journalMode, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "app.db")
+			db := openDB(t, path)
+			_, err := db.Exec("PRAGMA journal_mode=" + journalMode)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = db.Exec("CREATE TABLE items (id INTEGER PRIMARY KEY, name TEXT)")
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < 100; i++ {
+				err = insertBatch(db)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// write rows until the backup is done
+			ctx, cancel := context.WithCancel(context.Background())
+			var wg sync.WaitGroup
+			wg.Add(1)
+			var writeErr error
+			started := make(chan struct{})
+			go func() {
+				defer wg.Done()
+				for i := 0; ctx.Err() == nil; i++ {
+					writeErr = insertBatch(db)
+					if i == 0 {
+						close(started)
+					}
+					if writeErr != nil {
+						return
+					}
+					// without any pause, a writer starves the readers of a
+					// database which isn't in WAL mode until they time out
+					time.Sleep(time.Millisecond)
+				}
+			}()
+			<-started
+
+			source, err := NewSource([]string{path}, func() (string, func() error, error) {
+				return t.TempDir(), func() error { return nil }, nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			buf := &bytes.Buffer{}
+			writer := archive.NewWriter(buf)
+			err = source.Backup(context.Background(), writer)
+			cancel()
+			wg.Wait()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if writeErr != nil {
+				t.Fatalf("failed to write while backing up: %v", writeErr)
+			}
+			err = writer.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			snapshot := extract(t, buf.Bytes(), "app.db")
+			var integrity string
+			err = openDB(t, snapshot).QueryRow("PRAGMA integrity_check").Scan(&integrity)
+			if err != nil || integrity != "ok" {
+				t.Fatalf("got integrity %q, %v", integrity, err)
+			}
+			var count int
+			err = openDB(t, snapshot).QueryRow("SELECT count(*) FROM items").Scan(&count)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if count < 100*batchSize || count%batchSize != 0 {
+				t.Errorf("got %d rows, want at least %d in batches of %d", count, 100*batchSize, batchSize)
+			}
+		})
+	}
+}
+
+func TestBackupMissingDatabase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "missing.db")
+	source, err := NewSource([]string{path}, func() (string, func() error, error) {
+		return t.TempDir(), func() error { return nil }, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = source.Backup(context.Background(), archive.NewWriter(io.Discard))
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("missing database created")
+	}
+}
+
+// extract writes the file with the given name of an archive to a temporary
+// file and returns its path.
+func extract(t *testing.T, data []byte, name string) string {
+	t.Helper()
+	reader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, err := reader.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+
+	path := filepath.Join(t.TempDir(), name)
+	dst, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+	_, err = io.Copy(dst, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
@@ -1,10 +1,15 @@
 package mega
 
 import (
+	"context"
 	"fmt"
+	"io"
+	"os"
 	"path/filepath"
+	"sync"
 	"time"
 
+	"github.com/guillembonet/backup/targets"
 	"github.com/rs/zerolog/log"
 	mega "github.com/t3rm1n4l/go-mega"
 )
@@ -12,6 +17,12 @@ import (
 type Client struct {
 	client *mega.Mega
 	cfg    map[string]string
+
+	// mu guards logging in, which is only done once: every login starts a
+	// goroutine polling for events which never exits, and it must not race
+	// with requests using the session
+	mu       sync.Mutex
+	loggedIn bool
 }
 
 func NewClient(cfg map[string]string) (*Client, error) {
@@ -21,33 +32,13 @@ func NewClient(cfg map[string]string) (*Client, error) {
 	}, nil
 }
 
-func (c *Client) Upload(filePath string) error {
-	username, ok := c.cfg["username"]
-	if !ok {
-		return fmt.Errorf("missing username")
-	}
-	password, ok := c.cfg["password"]
-	if !ok {
-		return fmt.Errorf("missing password")
-	}
-	backupFolder, ok := c.cfg["backup_folder"]
-	if !ok {
-		return fmt.Errorf("missing backup_folder")
-	}
-
-	err := c.client.Login(username, password)
-	if err != nil {
-		return fmt.Errorf("failed to login: %w", err)
-	}
-	log.Debug().Msg("logged in")
-
-	backupsNode, err := c.getBackupNode(backupFolder)
+func (c *Client) Upload(ctx context.Context, filePath string) error {
+	backupsNode, err := c.connect()
 	if err != nil {
-		return fmt.Errorf("failed to create backups dir: %w", err)
+		return err
 	}
-	log.Debug().Msg("created backups dir")
 
-	fileNode, err := c.client.UploadFile(filePath, backupsNode, filepath.Base(filePath), nil)
+	fileNode, err := c.uploadFile(ctx, filePath, backupsNode)
 	if err != nil {
 		return fmt.Errorf("failed to upload file: %w", err)
 	}
@@ -60,49 +51,212 @@ func (c *Client) Upload(filePath string) error {
 	return nil
 }
 
-func (c *Client) Clean(backupExpirationDays int) error {
-	username, ok := c.cfg["username"]
-	if !ok {
-		return fmt.Errorf("missing username")
-	}
-	password, ok := c.cfg["password"]
-	if !ok {
-		return fmt.Errorf("missing password")
-	}
-	backupFolder, ok := c.cfg["backup_folder"]
-	if !ok {
-		return fmt.Errorf("missing backup_folder")
-	}
-
-	err := c.client.Login(username, password)
-	if err != nil {
-		return fmt.Errorf("failed to login: %w", err)
-	}
-	log.Debug().Msg("logged in")
-
-	backupsNode, err := c.getBackupNode(backupFolder)
+func (c *Client) Clean(ctx context.Context, backupExpirationDays int) (int, error) {
+	backupsNode, err := c.connect()
 	if err != nil {
-		return fmt.Errorf("failed to create backups dir: %w", err)
+		return 0, err
 	}
 
 	children, err := c.client.FS.GetChildren(backupsNode)
 	if err != nil {
-		return fmt.Errorf("failed to get children: %w", err)
+		return 0, fmt.Errorf("failed to get children: %w", err)
 	}
 
+	deleted := 0
 	for _, child := range children {
+		if ctx.Err() != nil {
+			return deleted, ctx.Err()
+		}
 		if child.GetTimeStamp().Before(time.Now().AddDate(0, 0, -backupExpirationDays)) {
 			err = c.client.Delete(child, false)
 			if err != nil {
-				return fmt.Errorf("failed to delete file: %w", err)
+				return deleted, fmt.Errorf("failed to delete file: %w", err)
 			}
+			deleted++
 			log.Debug().Str("name", child.GetName()).
 				Str("timestamp", child.GetTimeStamp().String()).
 				Int64("size", child.GetSize()).
 				Msg("deleted old backup")
 		}
 	}
-	return nil
+	return deleted, nil
+}
+
+func (c *Client) List(ctx context.Context) ([]targets.File, error) {
+	backupsNode, err := c.connect()
+	if err != nil {
+		return nil, err
+	}
+
+	children, err := c.client.FS.GetChildren(backupsNode)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get children: %w", err)
+	}
+
+	files := make([]targets.File, 0, len(children))
+	for _, child := range children {
+		if child.GetType() != mega.FILE {
+			continue
+		}
+		files = append(files, targets.File{
+			Name:    child.GetName(),
+			Size:    child.GetSize(),
+			ModTime: child.GetTimeStamp(),
+		})
+	}
+	return files, nil
+}
+
+func (c *Client) Download(ctx context.Context, fileName string, destination string) error {
+	backupsNode, err := c.connect()
+	if err != nil {
+		return err
+	}
+
+	children, err := c.client.FS.GetChildren(backupsNode)
+	if err != nil {
+		return fmt.Errorf("failed to get children: %w", err)
+	}
+
+	for _, child := range children {
+		if child.GetName() != fileName {
+			continue
+		}
+		err = c.downloadFile(ctx, child, destination)
+		if err != nil {
+			return fmt.Errorf("failed to download file: %w", err)
+		}
+		log.Debug().Str("name", child.GetName()).
+			Str("destination", destination).
+			Int64("size", child.GetSize()).
+			Msg("downloaded file")
+		return nil
+	}
+	return fmt.Errorf("backup %s not found", fileName)
+}
+
+// uploadFile uploads a file chunk by chunk, checking for cancellation between
+// chunks. Mega discards uploads which are never finished, so a cancelled
+// upload leaves nothing behind in the target.
+func (c *Client) uploadFile(ctx context.Context, filePath string, parent *mega.Node) (*mega.Node, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	upload, err := c.client.NewUpload(parent, filepath.Base(filePath), info.Size())
+	if err != nil {
+		return nil, err
+	}
+
+	for id := 0; id < upload.Chunks(); id++ {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		position, size, err := upload.ChunkLocation(id)
+		if err != nil {
+			return nil, err
+		}
+		chunk := make([]byte, size)
+		_, err = file.ReadAt(chunk, position)
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+
+		err = upload.UploadChunk(id, chunk)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return upload.Finish()
+}
+
+// downloadFile downloads a file chunk by chunk, checking for cancellation
+// between chunks. The partially downloaded file is removed on failure.
+func (c *Client) downloadFile(ctx context.Context, node *mega.Node, destination string) (err error) {
+	download, err := c.client.NewDownload(node)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Create(destination)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		file.Close()
+		if err != nil {
+			os.Remove(destination)
+		}
+	}()
+
+	for id := 0; id < download.Chunks(); id++ {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		chunk, err := download.DownloadChunk(id)
+		if err != nil {
+			return err
+		}
+		position, _, err := download.ChunkLocation(id)
+		if err != nil {
+			return err
+		}
+		_, err = file.WriteAt(chunk, position)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = file.Close()
+	if err != nil {
+		return err
+	}
+	return download.Finish()
+}
+
+// connect logs in with the configured credentials, unless the client already
+// did, and returns the node of the backup folder, creating it if needed.
+func (c *Client) connect() (*mega.Node, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	username, ok := c.cfg["username"]
+	if !ok {
+		return nil, fmt.Errorf("missing username")
+	}
+	password, ok := c.cfg["password"]
+	if !ok {
+		return nil, fmt.Errorf("missing password")
+	}
+	backupFolder, ok := c.cfg["backup_folder"]
+	if !ok {
+		return nil, fmt.Errorf("missing backup_folder")
+	}
+
+	if !c.loggedIn {
+		err := c.client.Login(username, password)
+		if err != nil {
+			return nil, fmt.Errorf("failed to login: %w", err)
+		}
+		c.loggedIn = true
+		log.Debug().Msg("logged in")
+	}
+
+	backupsNode, err := c.getBackupNode(backupFolder)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create backups dir: %w", err)
+	}
+	return backupsNode, nil
 }
 
 func (c *Client) getBackupNode(folderName string) (*mega.Node, error) {
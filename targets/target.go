@@ -1,6 +1,31 @@
 package targets
 
+import (
+	"context"
+	"time"
+)
+
 type Target interface {
-	Upload(filePath string) error
-	Clean(backupExpirationDays int) error
+	Upload(ctx context.Context, filePath string) error
+	// Clean deletes the backups older than the expiration and returns how many
+	// were deleted.
+	Clean(ctx context.Context, backupExpirationDays int) (int, error)
+}
+
+// File is a backup file stored in a target.
+type File struct {
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+// Lister is implemented by targets which can list the backup files they hold.
+type Lister interface {
+	List(ctx context.Context) ([]File, error)
+}
+
+// Downloader is implemented by targets which can fetch a previously uploaded
+// backup file back to the local filesystem.
+type Downloader interface {
+	Download(ctx context.Context, fileName string, destination string) error
 }